@@ -0,0 +1,193 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mogaika/god_of_war_tools/files/pss"
+	"github.com/mogaika/god_of_war_tools/files/vag"
+	"github.com/mogaika/god_of_war_tools/files/vpk"
+	"github.com/mogaika/god_of_war_tools/utils"
+	"github.com/mogaika/god_of_war_tools/utils/wav"
+)
+
+type Convert struct {
+	In        string
+	OutFolder string
+}
+
+// Converts file and writes result into outfname + own extension.
+// Returns names of created files
+type converter func(in io.Reader, outfname string) ([]string, error)
+
+var converters map[string]converter = map[string]converter{
+	".VAG": convertVag,
+	".VA1": convertVag,
+	".VA2": convertVag,
+	".VA3": convertVag,
+	".VA4": convertVag,
+	".VA5": convertVag,
+	".VPK": convertVpk,
+	".PSS": convertPss,
+	".PSW": convertPss,
+}
+
+func (c *Convert) DefineFlags(f *flag.FlagSet) {
+	f.StringVar(&c.In, "in", "", "*File or directory with unpacked files (VAG, VA1-5, VPK, PSS, PSW)")
+	f.StringVar(&c.OutFolder, "out", "./converted", " Directory to store result")
+}
+
+func createFile(fname string) (*os.File, error) {
+	if err := os.MkdirAll(path.Dir(fname), 0777); err != nil {
+		return nil, err
+	}
+	return os.Create(fname)
+}
+
+func writeWav(w *wav.Wav, outfname string) ([]string, error) {
+	fname := outfname + ".wav"
+	f, err := createFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := w.Encode(f); err != nil {
+		return nil, err
+	}
+	return []string{fname}, f.Close()
+}
+
+func convertVag(in io.Reader, outfname string) ([]string, error) {
+	v, err := vag.NewFromData(in)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := v.Wav()
+	if err != nil {
+		return nil, err
+	}
+	return writeWav(w, outfname)
+}
+
+func convertVpk(in io.Reader, outfname string) ([]string, error) {
+	v, err := vpk.NewFromData(in)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := v.Wav()
+	if err != nil {
+		return nil, err
+	}
+	return writeWav(w, outfname)
+}
+
+func convertPss(in io.Reader, outfname string) ([]string, error) {
+	names := make([]string, 0)
+	videoFiles := make([]*os.File, 0)
+	defer func() {
+		for _, f := range videoFiles {
+			f.Close()
+		}
+	}()
+
+	p, err := pss.Split(in, func(stream byte) (io.Writer, error) {
+		fname := outfname + ".m2v"
+		if len(videoFiles) != 0 {
+			fname = fmt.Sprintf("%s.%.2x.m2v", outfname, stream)
+		}
+
+		f, err := createFile(fname)
+		if err != nil {
+			return nil, err
+		}
+		videoFiles = append(videoFiles, f)
+		names = append(names, fname)
+		return f, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range videoFiles {
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+	}
+	videoFiles = nil
+
+	for i, a := range p.Audio {
+		w, err := a.Wav()
+		if err != nil {
+			return nil, err
+		}
+
+		audioName := outfname
+		if i != 0 {
+			audioName = fmt.Sprintf("%s.%.2x", outfname, a.SubStream)
+		}
+		wavNames, err := writeWav(w, audioName)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, wavNames...)
+	}
+
+	return names, nil
+}
+
+func (c *Convert) convertFile(fname string, outfname string) error {
+	conv, ok := converters[strings.ToUpper(path.Ext(fname))]
+	if !ok {
+		return nil
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	names, err := conv(f, outfname)
+	if err != nil {
+		return fmt.Errorf("Error when converting '%s': %v", fname, err)
+	}
+	log.Printf("Converted '%s': %s", fname, names)
+	return nil
+}
+
+func (c *Convert) Run() error {
+	if c.In == "" {
+		return errors.New("Input file or directory argument required")
+	}
+
+	stat, err := os.Stat(c.In)
+	if err != nil {
+		return err
+	}
+
+	if !stat.IsDir() {
+		return c.convertFile(c.In, path.Join(c.OutFolder, stat.Name()))
+	}
+
+	return filepath.Walk(c.In, func(fname string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(c.In, fname)
+		if err != nil {
+			return err
+		}
+		return c.convertFile(fname, path.Join(c.OutFolder, utils.PathPrepare(rel)))
+	})
+}
@@ -1,41 +1,92 @@
 package commands
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"log"
 	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/mogaika/god_of_war_tools/files/pack"
+	"github.com/mogaika/god_of_war_tools/files/tok"
 	"github.com/mogaika/god_of_war_tools/files/wad"
 	"github.com/mogaika/god_of_war_tools/utils"
 )
 
 type Extract struct {
-	WadFile   string
-	OutFolder string
-	Version   int
-	Print     bool
-	Dump      bool
+	GameFolder string
+	TokFile    string
+	WadFile    string
+	OutFolder  string
+	Version    int
+	Print      bool
+	Format     string
+	Dump       bool
+	KeepGoing  bool
+	Jobs       int
 }
 
 func (u *Extract) DefineFlags(f *flag.FlagSet) {
-	f.StringVar(&u.WadFile, "wad", "", "*Wad file")
+	f.StringVar(&u.WadFile, "wad", "", "*Wad file. If not presented, every wad file of game folder extracted")
+	f.StringVar(&u.GameFolder, "in", "", " Game folder or iso image. If presented, wad file taken from pack archives")
+	f.StringVar(&u.TokFile, "tok", "", " Custom tok file name (default is \"GODOFWAR.TOC\" in game folder)")
 	f.StringVar(&u.OutFolder, "out", "", " Directory to store result")
 	f.BoolVar(&u.Print, "print", false, " Print user-friendly tree representation of wad file")
+	f.StringVar(&u.Format, "format", "text", " Format of -print output: text, json or yaml")
 	f.BoolVar(&u.Dump, "dump", false, " Dump all wad nodes (.dump)")
+	f.IntVar(&u.Jobs, "j", 1, " Count of nodes extracted in parallel (count of wads when extracting game folder)")
+	f.BoolVar(&u.KeepGoing, "keep-going", false, " Do not stop on node errors, print report of failures at end")
 	f.IntVar(&u.Version, "v", utils.GAME_VERSION_UNKNOWN, " Version of game: 0-Auto; 1-GOW1; 2-GOW2")
 }
 
 func (u *Extract) Run() error {
 	if u.WadFile == "" {
+		if u.GameFolder != "" {
+			return u.runBatch()
+		}
 		return errors.New("Wad file argument required")
 	}
+	if u.Format != "text" && u.Format != "json" && u.Format != "yaml" {
+		return fmt.Errorf("Unknown print format '%s'", u.Format)
+	}
 
-	wadfile, err := os.Open(u.WadFile)
-	if err != nil {
-		return err
+	var wadfile io.ReaderAt
+	if u.GameFolder != "" {
+		src, srcCloser, err := openGameSource(u.GameFolder)
+		if err != nil {
+			return err
+		}
+		defer srcCloser.Close()
+
+		tokdata, err := decodeTok(src, u.TokFile, u.Version)
+		if err != nil {
+			return err
+		}
+
+		packfs := pack.NewFS(src, tokdata, tokdata.Version)
+		defer packfs.Close()
+
+		f, err := packfs.Open(u.WadFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		wadfile = f.(io.ReaderAt)
+	} else {
+		f, err := os.Open(u.WadFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		wadfile = f
 	}
-	defer wadfile.Close()
 
 	wd, err := wad.NewWad(wadfile, u.Version)
 	if err != nil {
@@ -43,16 +94,243 @@ func (u *Extract) Run() error {
 	}
 
 	if u.Print {
-		for _, nd := range wd.Nodes {
-			fmt.Println(nd)
+		switch u.Format {
+		case "text":
+			for _, nd := range wd.Nodes {
+				fmt.Println(nd)
+			}
+
+			fmt.Println("\nTags:")
+			for _, t := range wd.Tags {
+				fmt.Println(t.StringVersion(wd.Version))
+			}
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(wd.Info()); err != nil {
+				return err
+			}
+		case "yaml":
+			if err := utils.WriteYaml(os.Stdout, wd.Info()); err != nil {
+				return err
+			}
 		}
 	}
 
 	if u.OutFolder != "" {
-		if err := wd.Extract(u.OutFolder, u.Dump); err != nil {
+		report, err := wd.ExtractReport(u.OutFolder, wad.ExtractOptions{
+			Dump:      u.Dump,
+			KeepGoing: u.KeepGoing,
+			Jobs:      u.Jobs,
+		})
+		if err != nil {
+			return err
+		}
+
+		if u.KeepGoing {
+			if err := report.WriteSummary(os.Stdout); err != nil {
+				return err
+			}
+			if report.Failed() {
+				return fmt.Errorf("Extraction failed: %d nodes not extracted", len(report.Failures))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Result of extraction of one wad in batch mode
+type batchWad struct {
+	Wad      string       `json:"wad"`
+	Folder   string       `json:"folder"`
+	Error    string       `json:"error,omitempty"`
+	Failures []string     `json:"failures,omitempty"`
+	Info     *wad.WadInfo `json:"info,omitempty"`
+}
+
+// Combined index of batch extraction
+type batchIndex struct {
+	Version int         `json:"version"`
+	Wads    []*batchWad `json:"wads"`
+}
+
+const BATCH_INDEX_FILE_NAME = "index.json"
+
+// Returns sorted names of wad files. Pack filesystem lists all files
+// in root, unpacked game folder walked recursively
+func findWads(src fs.FS) ([]string, error) {
+	names := make([]string, 0)
+	err := fs.WalkDir(src, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
 			return err
 		}
+		if !d.IsDir() && strings.EqualFold(path.Ext(name), ".WAD") {
+			names = append(names, name)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+func openWad(src fs.FS, name string) (fs.File, io.ReaderAt, error) {
+	f, err := src.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, ok := f.(io.ReaderAt)
+	if !ok {
+		f.Close()
+		return nil, nil, &fs.PathError{Op: "readat", Path: name, Err: fs.ErrInvalid}
+	}
+	return f, r, nil
+}
+
+// Detects version by first wad which has known header
+func detectWadsVersion(src fs.FS, names []string) (int, error) {
+	var lastErr error
+	for _, name := range names {
+		f, r, err := openWad(src, name)
+		if err != nil {
+			return utils.GAME_VERSION_UNKNOWN, err
+		}
+		version, err := wad.DetectVersion(r)
+		f.Close()
+		if err == nil {
+			log.Printf("Detected wad version %d by '%s'", version, name)
+			return version, nil
+		}
+		lastErr = fmt.Errorf("'%s': %v", name, err)
+	}
+	return utils.GAME_VERSION_UNKNOWN, fmt.Errorf("Cannot detect version of wad files: %v", lastErr)
+}
+
+func (u *Extract) extractWad(src fs.FS, res *batchWad, version int) error {
+	f, r, err := openWad(src, res.Wad)
+	if err != nil {
+		return err
 	}
+	defer f.Close()
 
+	wd, err := wad.NewWad(r, version)
+	if err != nil {
+		return err
+	}
+	res.Info = wd.Info()
+
+	report, err := wd.ExtractReport(path.Join(u.OutFolder, res.Folder), wad.ExtractOptions{
+		Dump:      u.Dump,
+		KeepGoing: u.KeepGoing,
+	})
+	if err != nil {
+		return err
+	}
+	for _, failure := range report.Failures {
+		res.Failures = append(res.Failures, failure.String())
+	}
+	if report.Failed() {
+		return fmt.Errorf("%d nodes not extracted", len(report.Failures))
+	}
+	return nil
+}
+
+// Extracts every wad of game folder, iso image or pack archives
+// into own subfolder and writes combined index
+func (u *Extract) runBatch() error {
+	if u.OutFolder == "" {
+		return errors.New("Out folder argument required for extracting game folder")
+	}
+
+	src, srcCloser, err := openGameSource(u.GameFolder)
+	if err != nil {
+		return err
+	}
+	defer srcCloser.Close()
+
+	// use pack archives if toc presented, otherwise game folder is unpacked
+	wadsrc := src
+	if _, err := fs.Stat(src, tok.TOK_FILE_NAME); err == nil || u.TokFile != "" {
+		tokdata, err := decodeTok(src, u.TokFile, u.Version)
+		if err != nil {
+			return err
+		}
+		packfs := pack.NewFS(src, tokdata, tokdata.Version)
+		defer packfs.Close()
+		wadsrc = packfs
+	}
+
+	names, err := findWads(wadsrc)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errors.New("Wad files not found")
+	}
+
+	index := &batchIndex{Version: u.Version, Wads: make([]*batchWad, len(names))}
+	if index.Version == utils.GAME_VERSION_UNKNOWN {
+		if index.Version, err = detectWadsVersion(wadsrc, names); err != nil {
+			return err
+		}
+	}
+
+	jobs := u.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	work := make(chan *batchWad, len(names))
+	for i, name := range names {
+		index.Wads[i] = &batchWad{Wad: name, Folder: strings.TrimSuffix(name, path.Ext(name))}
+		work <- index.Wads[i]
+	}
+	close(work)
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for res := range work {
+				if err := u.extractWad(wadsrc, res, index.Version); err != nil {
+					log.Printf("Wad '%s' extraction error: %v", res.Wad, err)
+					res.Error = err.Error()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, res := range index.Wads {
+		if res.Error != "" {
+			failed++
+			fmt.Printf("%s: %s\n", res.Wad, res.Error)
+			for _, failure := range res.Failures {
+				fmt.Printf("  %s\n", failure)
+			}
+		}
+	}
+	fmt.Printf("Extracted wads: %d failed: %d\n", len(names)-failed, failed)
+
+	if err := os.MkdirAll(u.OutFolder, 0777); err != nil {
+		return err
+	}
+	f, err := os.Create(path.Join(u.OutFolder, BATCH_INDEX_FILE_NAME))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(index); err != nil {
+		return err
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("Extraction failed: %d of %d wads not extracted", failed, len(names))
+	}
 	return nil
 }
@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/mogaika/god_of_war_tools/files/pack"
+	"github.com/mogaika/god_of_war_tools/files/tok"
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+type Pack struct {
+	InFolder  string
+	OutFolder string
+	Version   int
+	TokFile   string
+	KeepDups  bool
+}
+
+func (p *Pack) DefineFlags(f *flag.FlagSet) {
+	f.StringVar(&p.InFolder, "in", "", "*Directory with unpacked files")
+	f.StringVar(&p.TokFile, "tok", "", "*Original tok file (GODOFWAR.TOC), used for files layout")
+	f.StringVar(&p.OutFolder, "out", "./packed", " Directory to store part*.pak files and new GODOFWAR.TOC")
+	f.IntVar(&p.Version, "v", utils.GAME_VERSION_UNKNOWN, " Version of game: 0-Auto; 1-GOW1; 2-GOW2")
+	f.BoolVar(&p.KeepDups, "dups", true, " Keep duplicate copies of files, like in original archive")
+}
+
+func (p *Pack) Run() error {
+	if p.InFolder == "" {
+		return errors.New("Unpacked files directory argument required")
+	}
+	if p.TokFile == "" {
+		return errors.New("Original tok file argument required")
+	}
+
+	tokfile, err := os.Open(p.TokFile)
+	if err != nil {
+		return err
+	}
+	defer tokfile.Close()
+
+	if p.Version == utils.GAME_VERSION_UNKNOWN {
+		p.Version, err = tok.DetectVersion(tokfile)
+		if err != nil {
+			return err
+		}
+		tokfile.Seek(0, os.SEEK_SET)
+		log.Printf("Detected tok version: %v\n", p.Version)
+	}
+
+	tokdata, err := tok.Decode(tokfile, p.Version)
+	if err != nil {
+		return err
+	}
+
+	return pack.Pack(p.InFolder, p.OutFolder, tokdata, p.Version, p.KeepDups)
+}
@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/mogaika/god_of_war_tools/files/iso"
+	"github.com/mogaika/god_of_war_tools/files/tok"
+)
+
+// Opens game folder or iso 9660 image as filesystem.
+// Closer must be closed when work with game files is done
+func openGameSource(in string) (fs.FS, io.Closer, error) {
+	stat, err := os.Stat(in)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if stat.IsDir() {
+		return os.DirFS(in), nopCloser{}, nil
+	}
+
+	f, err := os.Open(in)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img, err := iso.NewImage(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	log.Printf("Using iso image '%s'", in)
+	return img, f, nil
+}
+
+// Reads tok file from custom path or from game source
+func decodeTok(src fs.FS, tokFile string, version int) (*tok.TokFile, error) {
+	var rdr io.ReadSeeker
+	if tokFile != "" {
+		f, err := os.Open(tokFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		rdr = f
+	} else {
+		f, err := src.Open(tok.TOK_FILE_NAME)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		var ok bool
+		if rdr, ok = f.(io.ReadSeeker); !ok {
+			return nil, &fs.PathError{Op: "seek", Path: tok.TOK_FILE_NAME, Err: fs.ErrInvalid}
+		}
+	}
+	return tok.Decode(rdr, version)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
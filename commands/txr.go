@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"log"
+	"os"
+
+	file_gfx "github.com/mogaika/god_of_war_tools/files/gfx"
+	"github.com/mogaika/god_of_war_tools/files/txr"
+	"github.com/mogaika/god_of_war_tools/files/wad"
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+type TxrImport struct {
+	WadFile  string
+	NodePath string
+	PngFile  string
+	OutFile  string
+	Version  int
+	GfxIndex int
+	PalIndex int
+	KeepPal  bool
+}
+
+func (t *TxrImport) DefineFlags(f *flag.FlagSet) {
+	f.StringVar(&t.WadFile, "wad", "", "*Wad file")
+	f.StringVar(&t.NodePath, "node", "", "*Path of texture node in wad tree (see extract -print)")
+	f.StringVar(&t.PngFile, "png", "", "*Png image")
+	f.StringVar(&t.OutFile, "out", "", "*Result wad file")
+	f.IntVar(&t.Version, "v", utils.GAME_VERSION_UNKNOWN, " Version of game: 0-Auto; 1-GOW1; 2-GOW2")
+	f.IntVar(&t.GfxIndex, "gfx", 0, " Index of image data block in gfx")
+	f.IntVar(&t.PalIndex, "pal", 0, " Index of pallet data block in pallet gfx")
+	f.BoolVar(&t.KeepPal, "keep-pal", false, " Do not change pallet (if it shared with other textures), use nearest colors of it")
+}
+
+func (t *TxrImport) Run() error {
+	if t.WadFile == "" || t.NodePath == "" || t.PngFile == "" || t.OutFile == "" {
+		return errors.New("Wad file, node, png and out arguments required")
+	}
+	if t.OutFile == t.WadFile {
+		return errors.New("Result wad file must differ from source wad file")
+	}
+
+	wadfile, err := os.Open(t.WadFile)
+	if err != nil {
+		return err
+	}
+	defer wadfile.Close()
+
+	wd, err := wad.NewWad(wadfile, t.Version)
+	if err != nil {
+		return err
+	}
+
+	nd := wd.FindPath(t.NodePath)
+	if nd == nil {
+		return fmt.Errorf("Node '%s' not found", t.NodePath)
+	}
+	if nd = nd.Resolve(); nd == nil {
+		return fmt.Errorf("Node '%s' is unresolved link", t.NodePath)
+	}
+
+	decoded, err := nd.Decode()
+	if err != nil {
+		return err
+	}
+	texture, ok := decoded.(*txr.Texture)
+	if !ok {
+		return fmt.Errorf("Node '%s' is not texture", nd.Path)
+	}
+	if texture.GfxName == "" {
+		return fmt.Errorf("Texture '%s' without gfx", nd.Path)
+	}
+
+	gfxnd, gfx, err := txr.FindGfx(nd, texture.GfxName)
+	if err != nil {
+		return err
+	}
+	if t.GfxIndex < 0 || t.GfxIndex >= len(gfx.Data) {
+		return fmt.Errorf("Gfx data block index out of range: gfx has %d", len(gfx.Data))
+	}
+
+	// direct color gfx stores colors itself, pallet not used
+	var palnd *wad.WadNode
+	var pal *file_gfx.GFX
+	if !gfx.IsDirectColor() {
+		if texture.PalName == "" {
+			return fmt.Errorf("Texture '%s' with indexed gfx has no pallet", nd.Path)
+		}
+		if palnd, pal, err = txr.FindGfx(nd, texture.PalName); err != nil {
+			return err
+		}
+		if t.PalIndex < 0 || t.PalIndex >= len(pal.Data) {
+			return fmt.Errorf("Pallet data block index out of range: pallet has %d", len(pal.Data))
+		}
+	}
+
+	pngfile, err := os.Open(t.PngFile)
+	if err != nil {
+		return err
+	}
+	defer pngfile.Close()
+
+	img, err := png.Decode(pngfile)
+	if err != nil {
+		return err
+	}
+
+	if err := texture.SetImage(gfx, pal, t.GfxIndex, t.PalIndex, img, t.KeepPal); err != nil {
+		return err
+	}
+
+	gfxdata, err := gfx.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := gfxnd.SetData(gfxdata); err != nil {
+		return err
+	}
+	if pal != nil && !t.KeepPal {
+		paldata, err := pal.Marshal()
+		if err != nil {
+			return err
+		}
+		if err := palnd.SetData(paldata); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.Create(t.OutFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := wd.Write(out); err != nil {
+		return err
+	}
+
+	if palnd != nil {
+		log.Printf("Texture '%s' imported into gfx '%s' and pallet '%s'", nd.Path, gfxnd.Path, palnd.Path)
+	} else {
+		log.Printf("Texture '%s' imported into gfx '%s'", nd.Path, gfxnd.Path)
+	}
+	return out.Close()
+}
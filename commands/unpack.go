@@ -3,12 +3,14 @@ package commands
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"log"
-	"os"
 	"path"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/mogaika/god_of_war_tools/files/pack"
-	"github.com/mogaika/god_of_war_tools/files/tok"
 	"github.com/mogaika/god_of_war_tools/utils"
 )
 
@@ -18,14 +20,53 @@ type Unpack struct {
 	Version    int
 	TokFile    string
 	DoList     bool
+	Match      string
+	Regexp     string
+	Packs      string
 }
 
 func (u *Unpack) DefineFlags(f *flag.FlagSet) {
-	f.StringVar(&u.GameFolder, "in", "", "*Game folder. (Contains GODOFWAR.TOK file)")
+	f.StringVar(&u.GameFolder, "in", "", "*Game folder or iso image. (Contains GODOFWAR.TOC file)")
 	f.BoolVar(&u.DoList, "l", false, " Not unpack, only list files in pack archive")
 	f.StringVar(&u.OutFolder, "out", "./unpacked", " Directory to store result")
 	f.IntVar(&u.Version, "v", utils.GAME_VERSION_UNKNOWN, " Version of game: 0-Auto; 1-GOW1; 2-GOW2")
-	f.StringVar(&u.TokFile, "tok", "", " Custom tok file name (default is \"GODOFWAR.TOK\" in game folder)")
+	f.StringVar(&u.TokFile, "tok", "", " Custom tok file name (default is \"GODOFWAR.TOC\" in game folder)")
+	f.StringVar(&u.Match, "match", "", " Unpack only files matching comma separated glob patterns (\"*.WAD,R_*\")")
+	f.StringVar(&u.Regexp, "regexp", "", " Unpack only files matching regular expression")
+	f.StringVar(&u.Packs, "pack", "", " Unpack only files from comma separated pack numbers (\"1,2\" for part1.pak and part2.pak)")
+}
+
+func (u *Unpack) filter() (*pack.Filter, error) {
+	flt := &pack.Filter{}
+
+	if u.Match != "" {
+		for _, glob := range strings.Split(u.Match, ",") {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("Wrong glob pattern '%s': %v", glob, err)
+			}
+			flt.Globs = append(flt.Globs, glob)
+		}
+	}
+
+	if u.Regexp != "" {
+		re, err := regexp.Compile(u.Regexp)
+		if err != nil {
+			return nil, err
+		}
+		flt.Regexps = append(flt.Regexps, re)
+	}
+
+	if u.Packs != "" {
+		for _, spack := range strings.Split(u.Packs, ",") {
+			ipack, err := strconv.Atoi(strings.TrimSpace(spack))
+			if err != nil || ipack < 1 {
+				return nil, fmt.Errorf("Wrong pack number '%s'", spack)
+			}
+			flt.Packs = append(flt.Packs, uint32(ipack-1))
+		}
+	}
+
+	return flt, nil
 }
 
 func (u *Unpack) Run() error {
@@ -33,27 +74,32 @@ func (u *Unpack) Run() error {
 		return errors.New("game folder argument required")
 	}
 
-	if u.TokFile == "" {
-		u.TokFile = path.Join(u.GameFolder, "GODOFWAR.TOC")
+	filter, err := u.filter()
+	if err != nil {
+		return err
 	}
 
-	tokfile, err := os.Open(u.TokFile)
+	src, srcCloser, err := openGameSource(u.GameFolder)
 	if err != nil {
 		return err
 	}
-	defer tokfile.Close()
+	defer srcCloser.Close()
 
-	tokdata, err := tok.Decode(tokfile, u.Version)
+	tokdata, err := decodeTok(src, u.TokFile, u.Version)
 	if err != nil {
 		return err
 	}
 
 	if u.DoList {
-		for name, v := range tokdata {
-			log.Printf("pack: %d name: \"%s\" size: %d dups: %d", v.Pack, name, v.Size, v.Count)
+		for _, e := range tokdata.Entries {
+			if !filter.Match(e.Name, tokdata.Files[e.Name]) || !filter.AcceptPack(e.Pack) {
+				continue
+			}
+			log.Printf("idx: %.4d pack: %d sec: %.8x name: \"%s\" size: %d dups: %d",
+				e.Index, e.Pack, e.StartSec, e.Name, e.Size, tokdata.Files[e.Name].Count)
 		}
 		return nil
 	}
 
-	return pack.Unpack(u.GameFolder, u.OutFolder, tokdata, u.Version)
+	return pack.Unpack(src, u.OutFolder, tokdata, u.Version, filter)
 }
@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/mogaika/god_of_war_tools/files/pack"
+	"github.com/mogaika/god_of_war_tools/files/sanity"
+	"github.com/mogaika/god_of_war_tools/files/tok"
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+type Verify struct {
+	GameFolder string
+	Version    int
+	TokFile    string
+	SanityFile string
+}
+
+func (v *Verify) DefineFlags(f *flag.FlagSet) {
+	f.StringVar(&v.GameFolder, "in", "", "*Game folder or iso image. (Contains GODOFWAR.TOC file)")
+	f.IntVar(&v.Version, "v", utils.GAME_VERSION_UNKNOWN, " Version of game: 0-Auto; 1-GOW1; 2-GOW2")
+	f.StringVar(&v.TokFile, "tok", "", " Custom tok file name (default is \"GODOFWAR.TOC\" in game folder)")
+	f.StringVar(&v.SanityFile, "sanity", "", " Custom sanity file name (default is \"SANITY.TXT\" from pack archives)")
+}
+
+func (v *Verify) readSanity(packfs *pack.FS) (*sanity.Sanity, error) {
+	var r io.Reader
+	if v.SanityFile != "" {
+		f, err := os.Open(v.SanityFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	} else {
+		f, err := packfs.Open(sanity.FILE_NAME)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return sanity.NewFromData(r)
+}
+
+// Compares files from sanity file with toc. Returns count of problems
+func checkSanity(san *sanity.Sanity, tokdata *tok.TokFile) int {
+	problems := 0
+	mentioned := make(map[string]bool)
+
+	for _, line := range san.Unknown {
+		log.Printf("SANITY: unknown line '%s'", line)
+	}
+
+	// layout of sanity file is guessed, do not pass check if nothing recognised
+	if len(san.Records) == 0 {
+		log.Printf("SANITY: no file records recognised in sanity file (%d unknown lines)", len(san.Unknown))
+		problems++
+	}
+
+	for _, rec := range san.Records {
+		mentioned[rec.Name] = true
+		f, ok := tokdata.Files[rec.Name]
+		if !ok {
+			log.Printf("SANITY: line %d: '%s' not found in toc", rec.Line, rec.Name)
+			problems++
+		} else if rec.Size >= 0 && rec.Size != int64(f.Size) {
+			log.Printf("SANITY: line %d: '%s' size 0x%x, but toc size 0x%x", rec.Line, rec.Name, rec.Size, f.Size)
+			problems++
+		}
+	}
+
+	if len(san.Records) != 0 {
+		for name := range tokdata.Files {
+			if !mentioned[name] && name != sanity.FILE_NAME {
+				log.Printf("SANITY: '%s' from toc not mentioned", name)
+			}
+		}
+	}
+
+	log.Printf("SANITY: %d records, %d unknown lines, %d problems", len(san.Records), len(san.Unknown), problems)
+	return problems
+}
+
+func (v *Verify) Run() error {
+	if v.GameFolder == "" {
+		return errors.New("game folder argument required")
+	}
+
+	src, srcCloser, err := openGameSource(v.GameFolder)
+	if err != nil {
+		return err
+	}
+	defer srcCloser.Close()
+
+	tokdata, err := decodeTok(src, v.TokFile, v.Version)
+	if err != nil {
+		return err
+	}
+
+	packfs := pack.NewFS(src, tokdata, tokdata.Version)
+	defer packfs.Close()
+
+	problems := 0
+
+	if san, err := v.readSanity(packfs); err != nil {
+		log.Printf("SANITY: skipped, cannot read sanity file: %v", err)
+	} else {
+		problems += checkSanity(san, tokdata)
+	}
+
+	packProblems := packfs.Verify(v.Version)
+	for _, p := range packProblems {
+		log.Printf("PACK: %v", p)
+	}
+	problems += len(packProblems)
+
+	if problems != 0 {
+		return fmt.Errorf("Verification failed: %d problems found", problems)
+	}
+	log.Printf("Verification passed")
+	return nil
+}
@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/mogaika/god_of_war_tools/files/wad"
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+type WadReplace struct {
+	WadFile  string
+	NodePath string
+	DataFile string
+	OutFile  string
+	Version  int
+	Force    bool
+}
+
+func (r *WadReplace) DefineFlags(f *flag.FlagSet) {
+	f.StringVar(&r.WadFile, "wad", "", "*Wad file")
+	f.StringVar(&r.NodePath, "node", "", "*Path of node in wad tree (see extract -print)")
+	f.StringVar(&r.DataFile, "file", "", "*File with new node data")
+	f.StringVar(&r.OutFile, "out", "", "*Result wad file")
+	f.IntVar(&r.Version, "v", utils.GAME_VERSION_UNKNOWN, " Version of game: 0-Auto; 1-GOW1; 2-GOW2")
+	f.BoolVar(&r.Force, "force", false, " Replace even if format of new data differs from node format")
+}
+
+func (r *WadReplace) Run() error {
+	if r.WadFile == "" || r.NodePath == "" || r.DataFile == "" || r.OutFile == "" {
+		return errors.New("Wad file, node, file and out arguments required")
+	}
+	if r.OutFile == r.WadFile {
+		return errors.New("Result wad file must differ from source wad file")
+	}
+
+	wadfile, err := os.Open(r.WadFile)
+	if err != nil {
+		return err
+	}
+	defer wadfile.Close()
+
+	wd, err := wad.NewWad(wadfile, r.Version)
+	if err != nil {
+		return err
+	}
+
+	nd := wd.FindPath(r.NodePath)
+	if nd == nil {
+		return fmt.Errorf("Node '%s' not found", r.NodePath)
+	}
+	if nd.Type == wad.NODE_TYPE_LINK {
+		if nd.LinkTo == nil {
+			return fmt.Errorf("Node '%s' is unresolved link", r.NodePath)
+		}
+		log.Printf("Node '%s' is link, replacing '%s'", nd.Path, nd.LinkTo.Path)
+		nd = nd.LinkTo
+	}
+
+	data, err := ioutil.ReadFile(r.DataFile)
+	if err != nil {
+		return err
+	}
+	if len(data) < 4 {
+		return errors.New("New data too small")
+	}
+
+	if format := binary.LittleEndian.Uint32(data[0:4]); format != nd.Format {
+		if !r.Force {
+			return fmt.Errorf("Format of new data 0x%.8x differs from node format 0x%.8x (use -force)", format, nd.Format)
+		}
+		log.Printf("Format of new data 0x%.8x differs from node format 0x%.8x", format, nd.Format)
+	}
+
+	oldSize := nd.Size
+	if err := nd.SetData(data); err != nil {
+		return err
+	}
+
+	out, err := os.Create(r.OutFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if err := wd.Write(out); err != nil {
+		return err
+	}
+
+	log.Printf("Node '%s' replaced: size 0x%x -> 0x%x", nd.Path, oldSize, nd.Size)
+	return out.Close()
+}
@@ -4,11 +4,14 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"image"
 	"image/color"
 	"io"
 	"log"
 
 	"github.com/mogaika/god_of_war_tools/files/wad"
+	"github.com/mogaika/god_of_war_tools/utils"
+	"github.com/mogaika/god_of_war_tools/utils/gs"
 )
 
 const HEADER_SIZE = 0x18
@@ -19,11 +22,12 @@ type GFX struct {
 	Height   uint32
 	Encoding uint32
 	Bpi      uint32
+	Mipmaps  bool // data blocks are mip levels, every level halves size of previous
 	Data     [][]byte
 }
 
 func init() {
-	wad.PregisterExporter(GFX_MAGIC, &GFX{})
+	wad.RegisterDecoder(utils.GAME_VERSION_GOW_1, GFX_MAGIC, &GFX{})
 }
 
 func (gfx *GFX) GetPallet(idx int) (color.Palette, error) {
@@ -32,43 +36,314 @@ func (gfx *GFX) GetPallet(idx int) (color.Palette, error) {
 	colors := gfx.Width * gfx.Height
 
 	pallet := make(color.Palette, colors)
-	remap := []int{0, 2, 1, 3}
 
 	for i := range pallet {
 		si := i * 4
 
-		clr := color.RGBA{
+		clr := color.NRGBA{
 			R: palbuf[si],
 			G: palbuf[si+1],
 			B: palbuf[si+2],
-			A: byte(float32(palbuf[si+3]) * (255.0 / 128.0)),
+			A: alphaFromPs2(palbuf[si+3]),
+		}
+
+		newpos, err := gfx.palletPos(i)
+		if err != nil {
+			return nil, err
 		}
+		pallet[newpos] = clr
+	}
+	return pallet, nil
+}
 
-		switch gfx.Height {
-		case 2:
-			pallet[i] = clr
-		case 32:
-			fallthrough
-		case 16:
-			blockid := i / 8
-			blockpos := i % 8
+// Alpha of PS2 is stored in 0-128 range, 128 is opaque
+func alphaFromPs2(a byte) byte {
+	if a >= 128 {
+		return 255
+	}
+	return byte(float32(a) * (255.0 / 128.0))
+}
+
+func alphaToPs2(a byte) byte {
+	return byte((int(a)*128 + 127) / 255)
+}
 
-			newpos := blockpos + (remap[blockid%4]+(blockid/4)*4)*8
-			pallet[newpos] = clr
+// Position of color in pallet data block, storage order of CLUT differs
+// from logical order by swapped 8 color blocks
+func (gfx *GFX) palletPos(i int) (int, error) {
+	remap := []int{0, 2, 1, 3}
+
+	switch gfx.Height {
+	case 2:
+		return i, nil
+	case 32, 16:
+		blockid := i / 8
+		blockpos := i % 8
+		return blockpos + (remap[blockid%4]+(blockid/4)*4)*8, nil
+	}
+	return 0, fmt.Errorf("Wrong pallet height: %d", gfx.Height)
+}
+
+// Inverse of GetPallet. Alpha scaled back to 0-128 range
+func (gfx *GFX) SetPallet(idx int, pallet color.Palette) error {
+	colors := int(gfx.Width * gfx.Height)
+	if len(pallet) != colors {
+		return fmt.Errorf("Wrong pallet size %d, required %d", len(pallet), colors)
+	}
+
+	palbuf := make([]byte, colors*4)
+	for i := 0; i < colors; i++ {
+		pos, err := gfx.palletPos(i)
+		if err != nil {
+			return err
+		}
+
+		clr := color.NRGBAModel.Convert(pallet[pos]).(color.NRGBA)
+		si := i * 4
+		palbuf[si] = clr.R
+		palbuf[si+1] = clr.G
+		palbuf[si+2] = clr.B
+		palbuf[si+3] = alphaToPs2(clr.A)
+	}
+
+	gfx.Data[idx] = palbuf
+	return nil
+}
+
+// Size in pixels of data block. Frames have size of gfx, mip levels halve it
+func (gfx *GFX) BlockSize(idx int) (int, int) {
+	width, height := int(gfx.Width), int(gfx.Height)
+	if gfx.Mipmaps {
+		width, height = width>>uint(idx), height>>uint(idx)
+		if width < 1 {
+			width = 1
+		}
+		if height < 1 {
+			height = 1
+		}
+	}
+	return width, height
+}
+
+// Size in bytes of data block in file
+func (gfx *GFX) rawBlockSize(idx int) int {
+	width, height := gfx.BlockSize(idx)
+	return (width*height*int(gfx.Bpi) + 7) / 8
+}
+
+// True if gfx stores colors itself (32 bit RGBA or 16 bit RGBA5551), not pallet indexes
+func (gfx *GFX) IsDirectColor() bool {
+	return gfx.Bpi == 32 || gfx.Bpi == 16
+}
+
+// Pixel storage mode of gfx data
+func (gfx *GFX) psm() (int, error) {
+	switch gfx.Bpi {
+	case 32:
+		return gs.PSMCT32, nil
+	case 16:
+		return gs.PSMCT16, nil
+	case 8:
+		return gs.PSMT8, nil
+	case 4:
+		return gs.PSMT4, nil
+	}
+	return 0, errors.New("Unknown gfx bpi")
+}
+
+// Returns pixel storage mode of data block and true if block swizzled.
+// Encoding 0 means data uploaded into gs memory as PSMCT32 pixels, but blocks
+// smaller than page of their format (small mip levels) can not be uploaded
+// so, they uploaded in own format and their data is linear
+func (gfx *GFX) Layout(idx int) (int, bool, error) {
+	psm, err := gfx.psm()
+	if err != nil {
+		return 0, false, err
+	}
+	switch gfx.Encoding {
+	case 0:
+		width, height := gfx.BlockSize(idx)
+		return psm, gs.Uploadable(width, height, psm, gs.PSMCT32), nil
+	case 2:
+		return psm, false, nil
+	}
+	return 0, false, fmt.Errorf("Unsupported gfx encoding %d", gfx.Encoding)
+}
+
+// Returns data block in linear order. 4 bit indexes stay one in byte
+func (gfx *GFX) LinearData(idx int) ([]byte, error) {
+	psm, swizzled, err := gfx.Layout(idx)
+	if err != nil || !swizzled {
+		return gfx.Data[idx], err
+	}
+
+	width, height := gfx.BlockSize(idx)
+	data := gfx.Data[idx]
+	if psm == gs.PSMT4 {
+		data = pack4(data)
+	}
+	if data, err = gs.Unswizzle(data, width, height, psm, gs.PSMCT32); err != nil {
+		return nil, err
+	}
+	if psm == gs.PSMT4 {
+		data = unpack4(data, width*height)
+	}
+	return data, nil
+}
+
+// Inverse of LinearData
+func (gfx *GFX) SetLinearData(idx int, data []byte) error {
+	psm, swizzled, err := gfx.Layout(idx)
+	if err != nil {
+		return err
+	}
+
+	if swizzled {
+		width, height := gfx.BlockSize(idx)
+		if psm == gs.PSMT4 {
+			data = pack4(data)
+		}
+		if data, err = gs.Swizzle(data, width, height, psm, gs.PSMCT32); err != nil {
+			return err
+		}
+		if psm == gs.PSMT4 {
+			data = unpack4(data, width*height)
+		}
+	}
 
+	gfx.Data[idx] = data
+	return nil
+}
+
+// Packs 4 bit indexes two in byte, low nibble first, like file and gs memory store them
+func pack4(data []byte) []byte {
+	packed := make([]byte, (len(data)+1)/2)
+	for i, v := range data {
+		packed[i/2] |= (v & 0xf) << (uint(i&1) * 4)
+	}
+	return packed
+}
+
+func unpack4(packed []byte, count int) []byte {
+	data := make([]byte, count)
+	for i := range data {
+		data[i] = packed[i/2] >> (uint(i&1) * 4) & 0xf
+	}
+	return data
+}
+
+// Returns image of direct color gfx data block
+func (gfx *GFX) GetImage(idx int) (*image.NRGBA, error) {
+	if !gfx.IsDirectColor() {
+		return nil, fmt.Errorf("Gfx with bpi %d is not direct color", gfx.Bpi)
+	}
+	data, err := gfx.LinearData(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := gfx.BlockSize(idx)
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		var clr color.NRGBA
+		if gfx.Bpi == 32 {
+			clr = color.NRGBA{
+				R: data[i*4],
+				G: data[i*4+1],
+				B: data[i*4+2],
+				A: alphaFromPs2(data[i*4+3]),
+			}
+		} else {
+			v := binary.LittleEndian.Uint16(data[i*2:])
+			clr = color.NRGBA{
+				R: expand5(v),
+				G: expand5(v >> 5),
+				B: expand5(v >> 10),
+			}
+			if v&0x8000 != 0 {
+				clr.A = 255
+			}
+		}
+		img.SetNRGBA(i%width, i/width, clr)
+	}
+	return img, nil
+}
+
+// Inverse of GetImage. Size of image must be same as size of gfx
+func (gfx *GFX) SetImage(idx int, img image.Image) error {
+	if !gfx.IsDirectColor() {
+		return fmt.Errorf("Gfx with bpi %d is not direct color", gfx.Bpi)
+	}
+
+	width, height := gfx.BlockSize(idx)
+	bounds := img.Bounds()
+	if bounds.Dx() != width || bounds.Dy() != height {
+		return fmt.Errorf("Wrong image size %dx%d, required %dx%d", bounds.Dx(), bounds.Dy(), width, height)
+	}
+
+	data := make([]byte, width*height*int(gfx.Bpi)/8)
+	for i := 0; i < width*height; i++ {
+		clr := color.NRGBAModel.Convert(img.At(bounds.Min.X+i%width, bounds.Min.Y+i/width)).(color.NRGBA)
+		if gfx.Bpi == 32 {
+			data[i*4] = clr.R
+			data[i*4+1] = clr.G
+			data[i*4+2] = clr.B
+			data[i*4+3] = alphaToPs2(clr.A)
+		} else {
+			v := reduce5(clr.R) | reduce5(clr.G)<<5 | reduce5(clr.B)<<10
+			if clr.A >= 128 {
+				v |= 0x8000
+			}
+			binary.LittleEndian.PutUint16(data[i*2:], v)
+		}
+	}
+
+	return gfx.SetLinearData(idx, data)
+}
+
+// 5 bit color channel to 8 bit
+func expand5(v uint16) byte {
+	c := byte(v & 0x1f)
+	return c<<3 | c>>2
+}
+
+func reduce5(c byte) uint16 {
+	return (uint16(c)*31 + 127) / 255
+}
+
+// Returns gfx file data, data blocks stored one after another
+func (gfx *GFX) Marshal() ([]byte, error) {
+	buf := make([]byte, HEADER_SIZE)
+	binary.LittleEndian.PutUint32(buf[0:4], GFX_MAGIC)
+	binary.LittleEndian.PutUint32(buf[4:8], gfx.Width)
+	binary.LittleEndian.PutUint32(buf[8:12], gfx.Height)
+	binary.LittleEndian.PutUint32(buf[12:16], gfx.Encoding)
+	binary.LittleEndian.PutUint32(buf[16:20], gfx.Bpi)
+	binary.LittleEndian.PutUint32(buf[20:24], uint32(len(gfx.Data)))
+
+	for iData, data := range gfx.Data {
+		raw := make([]byte, gfx.rawBlockSize(iData))
+		switch gfx.Bpi {
+		case 4:
+			copy(raw, pack4(data))
+		case 8, 16, 32:
+			copy(raw, data)
 		default:
-			return nil, fmt.Errorf("Wrong pallet height: %d", gfx.Height)
+			return nil, errors.New("Unknown gfx bpi")
 		}
+		buf = append(buf, raw...)
 	}
-	return pallet, nil
+	return buf, nil
 }
 
 func (gfx *GFX) String() string {
-	return fmt.Sprintf("GFX Width: %d Height: %d Bpi: %d Encoding: %d Datas: %d\n",
-		gfx.Width, gfx.Height, gfx.Bpi, gfx.Encoding, len(gfx.Data))
+	return fmt.Sprintf("GFX Width: %d Height: %d Bpi: %d Encoding: %d Datas: %d Mipmaps: %t\n",
+		gfx.Width, gfx.Height, gfx.Bpi, gfx.Encoding, len(gfx.Data), gfx.Mipmaps)
 }
 
-func NewFromData(fgfx io.ReaderAt) (*GFX, error) {
+// Layout of data blocks detected by size of data: blocks of same size are
+// frames (or pallets), blocks of halving size are mip levels
+func NewFromData(fgfx io.ReaderAt, size int64) (*GFX, error) {
 	buf := make([]byte, HEADER_SIZE)
 	if _, err := fgfx.ReadAt(buf, 0); err != nil {
 		return nil, err
@@ -87,50 +362,53 @@ func NewFromData(fgfx io.ReaderAt) (*GFX, error) {
 		Data:     make([][]byte, binary.LittleEndian.Uint32(buf[20:24])),
 	}
 
-	dataBlockCount := int(binary.LittleEndian.Uint32(buf[20:24]))
+	switch gfx.Bpi {
+	case 4, 8, 16, 32:
+	default:
+		return nil, errors.New("Unknown gfx bpi")
+	}
 
-	for iData := 0; iData < dataBlockCount; iData++ {
-		rawData := make([]byte, (gfx.Width*gfx.Height*gfx.Bpi)/8)
-		var data []byte
+	payload := size - HEADER_SIZE
+	if gfx.dataSize() > payload {
+		gfx.Mipmaps = true
+		if gfx.dataSize() > payload {
+			return nil, fmt.Errorf("Gfx data too small: %d bytes for %d blocks of %dx%d", payload, len(gfx.Data), gfx.Width, gfx.Height)
+		}
+	}
 
-		_, err := fgfx.ReadAt(rawData, HEADER_SIZE)
-		if err != nil {
+	offset := int64(HEADER_SIZE)
+	for iData := range gfx.Data {
+		rawData := make([]byte, gfx.rawBlockSize(iData))
+		if _, err := fgfx.ReadAt(rawData, offset); err != nil {
 			return nil, err
 		}
+		offset += int64(len(rawData))
 
-		switch gfx.Bpi {
-		case 4:
-			data = make([]byte, gfx.Width*gfx.Height)
-			for i, v := range rawData {
-				data[i*2] = v & 0xf
-				data[i*2+1] = (v >> 4) & 0xf
-			}
-		case 8:
-			data = rawData
-		case 32:
-			data = rawData
-		default:
-			return nil, errors.New("Unknown gfx bpi")
+		if gfx.Bpi == 4 {
+			width, height := gfx.BlockSize(iData)
+			gfx.Data[iData] = unpack4(rawData, width*height)
+		} else {
+			gfx.Data[iData] = rawData
 		}
-
-		gfx.Data[iData] = data
 	}
 
 	return gfx, nil
 }
 
-func (*GFX) ExtractFromNode(nd *wad.WadNode, outfname string) error {
-	log.Printf("Gfx '%s' extraction", nd.Path)
-	reader, err := nd.DataReader()
-	if err != nil {
-		return err
+// Summary size of data blocks in file
+func (gfx *GFX) dataSize() int64 {
+	var size int64
+	for i := range gfx.Data {
+		size += int64(gfx.rawBlockSize(i))
 	}
+	return size
+}
 
-	mat, err := NewFromData(reader)
+func (*GFX) Decode(nd *wad.WadNode) (interface{}, error) {
+	log.Printf("Gfx '%s' decoding", nd.Path)
+	reader, err := nd.DataReader()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	nd.Cache = mat
-	return nil
+	return NewFromData(reader, reader.Size())
 }
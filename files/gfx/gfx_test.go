@@ -0,0 +1,116 @@
+package gfx
+
+import (
+	"bytes"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestRgba5551(t *testing.T) {
+	if expand5(0) != 0 || expand5(0x1f) != 255 {
+		t.Fatalf("Wrong range of expanded channel: %d - %d", expand5(0), expand5(0x1f))
+	}
+	for v := uint16(0); v < 32; v++ {
+		if got := reduce5(expand5(v)); got != v {
+			t.Errorf("reduce5(expand5(%d)) = %d", v, got)
+		}
+	}
+	for c := 0; c < 256; c++ {
+		// 8 bit channel rounded to nearest of 32 levels
+		if d := int(expand5(reduce5(byte(c)))) - c; d < -4 || d > 4 {
+			t.Errorf("Channel %d stored with error %d", c, d)
+		}
+	}
+}
+
+func TestAlphaPs2(t *testing.T) {
+	if alphaFromPs2(0) != 0 || alphaFromPs2(0x80) != 255 || alphaFromPs2(0xff) != 255 {
+		t.Fatal("Wrong alpha range")
+	}
+	if alphaToPs2(0) != 0 || alphaToPs2(255) != 0x80 {
+		t.Fatal("Wrong ps2 alpha range")
+	}
+	for a := 0; a <= 0x80; a++ {
+		if got := alphaToPs2(alphaFromPs2(byte(a))); got != byte(a) {
+			t.Errorf("Alpha %d stored back as %d", a, got)
+		}
+	}
+}
+
+func TestImageRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, bpi := range []uint32{16, 32} {
+		for _, encoding := range []uint32{0, 2} {
+			// 64x64 is swizzled with encoding 0, its small mip levels are not
+			gfx := &GFX{Width: 64, Height: 64, Encoding: encoding, Bpi: bpi, Mipmaps: true, Data: make([][]byte, 4)}
+			for i := range gfx.Data {
+				data := make([]byte, gfx.rawBlockSize(i))
+				rnd.Read(data)
+				if bpi == 32 {
+					for j := 3; j < len(data); j += 4 {
+						data[j] %= 0x81
+					}
+				}
+				gfx.Data[i] = data
+			}
+
+			for i, src := range gfx.Data {
+				img, err := gfx.GetImage(i)
+				if err != nil {
+					t.Fatalf("bpi %d encoding %d block %d: %v", bpi, encoding, i, err)
+				}
+				if err := gfx.SetImage(i, img); err != nil {
+					t.Fatalf("bpi %d encoding %d block %d: %v", bpi, encoding, i, err)
+				}
+				if !bytes.Equal(gfx.Data[i], src) {
+					t.Errorf("bpi %d encoding %d block %d: round trip differs", bpi, encoding, i)
+				}
+			}
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	gfx := &GFX{Width: 64, Height: 64, Encoding: 0, Bpi: 32, Data: [][]byte{make([]byte, 64*64*4)}}
+	if _, swizzled, err := gfx.Layout(0); err != nil || !swizzled {
+		t.Fatalf("64x64 gfx of encoding 0 not swizzled: %v", err)
+	}
+	small := &GFX{Width: 8, Height: 8, Encoding: 0, Bpi: 16}
+	if _, swizzled, err := small.Layout(0); err != nil || swizzled {
+		t.Fatalf("8x8 gfx of encoding 0 swizzled: %v", err)
+	}
+}
+
+func TestSetPalletInvertsGetPallet(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	for _, height := range []uint32{2, 16, 32} {
+		width := uint32(16)
+		if height == 2 {
+			width = 8
+		}
+		src := make([]byte, width*height*4)
+		rnd.Read(src)
+		for j := 3; j < len(src); j += 4 {
+			src[j] %= 0x81
+		}
+		pal := &GFX{Width: width, Height: height, Bpi: 32, Encoding: 0, Data: [][]byte{src}}
+
+		pallet, err := pal.GetPallet(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if height != 2 {
+			// second block of 8 colors stored third
+			if want := (color.NRGBA{src[16*4], src[16*4+1], src[16*4+2], alphaFromPs2(src[16*4+3])}); pallet[8] != want {
+				t.Errorf("Height %d: color 8 is %v, want %v", height, pallet[8], want)
+			}
+		}
+		if err := pal.SetPallet(0, pallet); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pal.Data[0], src) {
+			t.Errorf("Height %d: SetPallet differs from source", height)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package iso
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+const (
+	SYSTEM_AREA_SECTORS = 16
+
+	VOLUME_DESCRIPTOR_PRIMARY    = 1
+	VOLUME_DESCRIPTOR_TERMINATOR = 0xff
+
+	ROOT_RECORD_OFFSET = 156
+
+	RECORD_FLAG_DIRECTORY = 0x2
+	RECORD_FLAG_MULTI     = 0x80
+)
+
+// Record of file or directory in iso 9660 image
+type Record struct {
+	Name     string // without ';1' version suffix
+	Start    uint32 // first sector of data
+	Size     uint32
+	Flags    uint8
+	Modified time.Time
+}
+
+type Image struct {
+	reader io.ReaderAt
+	Root   *Record
+}
+
+func (r *Record) IsDir() bool {
+	return r.Flags&RECORD_FLAG_DIRECTORY != 0
+}
+
+func parseRecord(buf []byte) *Record {
+	nameLen := int(buf[32])
+	name := string(buf[33 : 33+nameLen])
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	name = strings.TrimSuffix(name, ".")
+
+	date := buf[18:25]
+	// timezone in 15 minute intervals from GMT
+	zone := time.FixedZone("", int(int8(date[6]))*15*60)
+
+	return &Record{
+		Name:  name,
+		Start: binary.LittleEndian.Uint32(buf[2:6]),
+		Size:  binary.LittleEndian.Uint32(buf[10:14]),
+		Flags: buf[25],
+		Modified: time.Date(1900+int(date[0]), time.Month(date[1]), int(date[2]),
+			int(date[3]), int(date[4]), int(date[5]), 0, zone),
+	}
+}
+
+func (img *Image) readDir(dir *Record) ([]*Record, error) {
+	if !dir.IsDir() {
+		return nil, errors.New("Record is not directory")
+	}
+
+	data := make([]byte, dir.Size)
+	if _, err := img.reader.ReadAt(data, int64(dir.Start)*utils.SectorSize); err != nil {
+		return nil, err
+	}
+
+	records := make([]*Record, 0)
+	for pos := 0; pos < len(data); {
+		recLen := int(data[pos])
+		if recLen == 0 {
+			// records never cross sector border, rest of sector is zeroed
+			pos = (pos/utils.SectorSize + 1) * utils.SectorSize
+			continue
+		}
+		if recLen < 34 || pos+recLen > len(data) || 33+int(data[pos+32]) > recLen {
+			return nil, fmt.Errorf("Corrupted directory record at sector 0x%x", dir.Start+uint32(pos/utils.SectorSize))
+		}
+
+		rec := data[pos : pos+recLen]
+		pos += recLen
+
+		// skip '.' and '..' records
+		if rec[32] == 1 && (rec[33] == 0 || rec[33] == 1) {
+			continue
+		}
+
+		records = append(records, parseRecord(rec))
+	}
+	return records, nil
+}
+
+// Finds record by slash separated path. Names are case insensitive
+func (img *Image) Lookup(name string) (*Record, error) {
+	rec := img.Root
+	name = strings.Trim(utils.PathPrepare(name), "/")
+	if name == "" || name == "." {
+		return rec, nil
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		records, err := img.readDir(rec)
+		if err != nil {
+			return nil, err
+		}
+
+		rec = nil
+		for _, r := range records {
+			if strings.EqualFold(r.Name, part) {
+				rec = r
+				break
+			}
+		}
+		if rec == nil {
+			return nil, fs.ErrNotExist
+		}
+	}
+	return rec, nil
+}
+
+// List of records in directory
+func (img *Image) ReadDirRecords(name string) ([]*Record, error) {
+	dir, err := img.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return img.readDir(dir)
+}
+
+func (img *Image) DataReader(rec *Record) *io.SectionReader {
+	return io.NewSectionReader(img.reader, int64(rec.Start)*utils.SectorSize, int64(rec.Size))
+}
+
+func (img *Image) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	rec, err := img.Lookup(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if rec.Flags&RECORD_FLAG_MULTI != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("Multi extent files not supported")}
+	}
+	return &File{SectionReader: img.DataReader(rec), img: img, rec: rec}, nil
+}
+
+func NewImage(r io.ReaderAt) (*Image, error) {
+	buf := make([]byte, utils.SectorSize)
+
+	for sec := int64(SYSTEM_AREA_SECTORS); ; sec++ {
+		if _, err := r.ReadAt(buf, sec*utils.SectorSize); err != nil {
+			return nil, err
+		}
+
+		if string(buf[1:6]) != "CD001" {
+			return nil, errors.New("Wrong volume descriptor magic. Not iso 9660 image")
+		}
+
+		switch buf[0] {
+		case VOLUME_DESCRIPTOR_PRIMARY:
+			if blockSize := binary.LittleEndian.Uint16(buf[128:130]); blockSize != utils.SectorSize {
+				return nil, fmt.Errorf("Unsupported logical block size: %d", blockSize)
+			}
+
+			return &Image{
+				reader: r,
+				Root:   parseRecord(buf[ROOT_RECORD_OFFSET : ROOT_RECORD_OFFSET+34]),
+			}, nil
+		case VOLUME_DESCRIPTOR_TERMINATOR:
+			return nil, errors.New("Primary volume descriptor not found")
+		}
+	}
+}
+
+// Opened file or directory, implements fs.File, io.ReaderAt and io.Seeker
+type File struct {
+	*io.SectionReader
+	img    *Image
+	rec    *Record
+	dirPos int
+}
+
+func (f *File) Close() error {
+	return nil
+}
+
+func (f *File) Stat() (fs.FileInfo, error) {
+	return fileInfo{f.rec}, nil
+}
+
+func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
+	records, err := f.img.readDir(f.rec)
+	if err != nil {
+		return nil, err
+	}
+
+	records = records[f.dirPos:]
+	if n > 0 {
+		if len(records) == 0 {
+			return nil, io.EOF
+		}
+		if len(records) > n {
+			records = records[:n]
+		}
+	}
+	f.dirPos += len(records)
+
+	entries := make([]fs.DirEntry, len(records))
+	for i, r := range records {
+		entries[i] = fs.FileInfoToDirEntry(fileInfo{r})
+	}
+	return entries, nil
+}
+
+type fileInfo struct {
+	rec *Record
+}
+
+func (fi fileInfo) Name() string {
+	if fi.rec.Name == "\x00" {
+		return "."
+	}
+	return path.Base(fi.rec.Name)
+}
+
+func (fi fileInfo) Size() int64        { return int64(fi.rec.Size) }
+func (fi fileInfo) ModTime() time.Time { return fi.rec.Modified }
+func (fi fileInfo) IsDir() bool        { return fi.rec.IsDir() }
+func (fi fileInfo) Sys() interface{}   { return fi.rec }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.rec.IsDir() {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
@@ -0,0 +1,141 @@
+package iso
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+const (
+	testRootSector = 18
+	testSubSector  = 20
+	testDataSector = 21
+	testFilesCount = 50
+)
+
+func testRecord(name string, start, size uint32, flags uint8) []byte {
+	recLen := 33 + len(name)
+	recLen += recLen & 1
+	rec := make([]byte, recLen)
+	rec[0] = byte(recLen)
+	binary.LittleEndian.PutUint32(rec[2:], start)
+	binary.BigEndian.PutUint32(rec[6:], start)
+	binary.LittleEndian.PutUint32(rec[10:], size)
+	binary.BigEndian.PutUint32(rec[14:], size)
+	copy(rec[18:25], []byte{105, 3, 24, 12, 30, 0, 0})
+	rec[25] = flags
+	rec[32] = byte(len(name))
+	copy(rec[33:], name)
+	return rec
+}
+
+// Writes records into directory sectors, records do not cross sector border
+func testDir(records ...[]byte) []byte {
+	dir := make([]byte, 0)
+	for _, rec := range records {
+		if len(dir)/utils.SectorSize != (len(dir)+len(rec)-1)/utils.SectorSize {
+			dir = append(dir, make([]byte, utils.SectorSize-len(dir)%utils.SectorSize)...)
+		}
+		dir = append(dir, rec...)
+	}
+	return append(dir, make([]byte, utils.SectorSize-len(dir)%utils.SectorSize)...)
+}
+
+func testContent(i int) []byte {
+	return []byte(fmt.Sprintf("content of file %d", i))
+}
+
+func testImage(t *testing.T) []byte {
+	root := [][]byte{
+		testRecord("\x00", testRootSector, 2*utils.SectorSize, RECORD_FLAG_DIRECTORY),
+		testRecord("\x01", testRootSector, 2*utils.SectorSize, RECORD_FLAG_DIRECTORY),
+	}
+	for i := 0; i < testFilesCount; i++ {
+		root = append(root, testRecord(fmt.Sprintf("FILE%.2d.BIN;1", i), uint32(testDataSector+i), uint32(len(testContent(i))), 0))
+	}
+	root = append(root, testRecord("SUB", testSubSector, utils.SectorSize, RECORD_FLAG_DIRECTORY))
+	rootData := testDir(root...)
+	if len(rootData) != 2*utils.SectorSize {
+		t.Fatalf("Root directory must take 2 sectors, got 0x%x bytes", len(rootData))
+	}
+
+	sub := testDir(
+		testRecord("\x00", testSubSector, utils.SectorSize, RECORD_FLAG_DIRECTORY),
+		testRecord("\x01", testRootSector, 2*utils.SectorSize, RECORD_FLAG_DIRECTORY),
+		testRecord("GODOFWAR.TOC;1", testDataSector+testFilesCount, 4, 0),
+	)
+
+	img := make([]byte, (testDataSector+testFilesCount+1)*utils.SectorSize)
+	pvd := img[16*utils.SectorSize:]
+	pvd[0] = VOLUME_DESCRIPTOR_PRIMARY
+	copy(pvd[1:], "CD001")
+	binary.LittleEndian.PutUint16(pvd[128:], utils.SectorSize)
+	copy(pvd[ROOT_RECORD_OFFSET:], root[0])
+	term := img[17*utils.SectorSize:]
+	term[0] = VOLUME_DESCRIPTOR_TERMINATOR
+	copy(term[1:], "CD001")
+
+	copy(img[testRootSector*utils.SectorSize:], rootData)
+	copy(img[testSubSector*utils.SectorSize:], sub)
+	for i := 0; i < testFilesCount; i++ {
+		copy(img[(testDataSector+i)*utils.SectorSize:], testContent(i))
+	}
+	copy(img[(testDataSector+testFilesCount)*utils.SectorSize:], "TOC!")
+	return img
+}
+
+func TestImage(t *testing.T) {
+	img, err := NewImage(bytes.NewReader(testImage(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := fs.ReadDir(img, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != testFilesCount+1 {
+		t.Fatalf("Wrong root entries count %d", len(entries))
+	}
+	if entries[0].Name() != "FILE00.BIN" {
+		t.Fatalf("Version suffix not removed: %q", entries[0].Name())
+	}
+
+	// records of second sector of directory
+	for _, i := range []int{0, testFilesCount - 1} {
+		data, err := fs.ReadFile(img, fmt.Sprintf("file%.2d.bin", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, testContent(i)) {
+			t.Fatalf("Wrong content of file %d: %q", i, data)
+		}
+	}
+
+	rec, err := img.Lookup("Sub/GodOfWar.toc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Name != "GODOFWAR.TOC" || rec.Size != 4 || rec.Modified.Year() != 2005 {
+		t.Fatalf("Wrong record %+v", rec)
+	}
+	data, err := io.ReadAll(img.DataReader(rec))
+	if err != nil || string(data) != "TOC!" {
+		t.Fatalf("Wrong toc data %q: %v", data, err)
+	}
+
+	if _, err := img.Open("SUB/MISSING"); err == nil {
+		t.Fatal("No error for missing file")
+	}
+}
+
+func TestNotIso(t *testing.T) {
+	if _, err := NewImage(bytes.NewReader(make([]byte, 20*utils.SectorSize))); err == nil {
+		t.Fatal("No error for image without volume descriptors")
+	}
+}
@@ -28,7 +28,7 @@ const HEADER_SIZE = 0x38
 const LAYER_SIZE = 0x40
 
 func init() {
-	wad.PregisterExporter(MAT_MAGIC, &Material{})
+	wad.RegisterDecoder(utils.GAME_VERSION_GOW_1, MAT_MAGIC, &Material{})
 }
 
 func NewFromData(fmat io.ReaderAt) (*Material, error) {
@@ -73,18 +73,11 @@ func NewFromData(fmat io.ReaderAt) (*Material, error) {
 	return mat, nil
 }
 
-func (*Material) ExtractFromNode(nd *wad.WadNode, outfname string) error {
-	log.Printf("Mat '%s' extraction", nd.Path)
+func (*Material) Decode(nd *wad.WadNode) (interface{}, error) {
+	log.Printf("Mat '%s' decoding", nd.Path)
 	reader, err := nd.DataReader()
 	if err != nil {
-		return err
-	}
-
-	mat, err := NewFromData(reader)
-	if err != nil {
-		return err
+		return nil, err
 	}
-
-	nd.Cache = mat
-	return nil
+	return NewFromData(reader)
 }
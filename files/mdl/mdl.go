@@ -7,6 +7,7 @@ import (
 	"math"
 
 	"github.com/mogaika/god_of_war_tools/files/wad"
+	"github.com/mogaika/god_of_war_tools/utils"
 )
 
 type Model struct {
@@ -17,7 +18,7 @@ const MODEL_MAGIC = 0x2000f
 const FILE_SIZE = 0x48
 
 func init() {
-	wad.PregisterExporter(MODEL_MAGIC, &Model{})
+	wad.RegisterDecoder(utils.GAME_VERSION_GOW_1, MODEL_MAGIC, &Model{})
 }
 
 func NewFromData(rdr io.ReaderAt) (*Model, error) {
@@ -39,17 +40,10 @@ func NewFromData(rdr io.ReaderAt) (*Model, error) {
 	return mdl, nil
 }
 
-func (*Model) ExtractFromNode(nd *wad.WadNode, outfname string) error {
+func (*Model) Decode(nd *wad.WadNode) (interface{}, error) {
 	reader, err := nd.DataReader()
 	if err != nil {
-		return err
-	}
-
-	mdl, err := NewFromData(reader)
-	if err != nil {
-		return err
+		return nil, err
 	}
-
-	nd.Cache = mdl
-	return nil
+	return NewFromData(reader)
 }
@@ -12,6 +12,7 @@ import (
 
 	"github.com/mogaika/god_of_war_tools/files/mat"
 	"github.com/mogaika/god_of_war_tools/files/wad"
+	"github.com/mogaika/god_of_war_tools/utils"
 )
 
 type MeshPacket struct {
@@ -46,7 +47,8 @@ type Mesh struct {
 const MESH_MAGIC = 0x1000f
 
 func init() {
-	wad.PregisterExporter(MESH_MAGIC, &Mesh{})
+	wad.RegisterDecoder(utils.GAME_VERSION_GOW_1, MESH_MAGIC, &Mesh{})
+	wad.RegisterExporter(utils.GAME_VERSION_GOW_1, MESH_MAGIC, &ObjExporter{})
 }
 
 func NewFromData(rdat io.Reader) (*Mesh, error) {
@@ -286,62 +288,102 @@ func (ms *Mesh) ExtractObj(textures []string, outfname string) ([]string, error)
 	return []string{ofileName}, nil
 }
 
-func (*Mesh) ExtractFromNode(nd *wad.WadNode, outfname string) error {
-	log.Printf("\n\nMesh '%s' extraction", nd.Name)
+// Exports mesh to obj file
+type ObjExporter struct{}
 
-	pathPrefix := "../"
-	for i := 0; i < nd.Depth; i++ {
-		pathPrefix += "../"
+func (*Mesh) Decode(nd *wad.WadNode) (interface{}, error) {
+	reader, err := nd.DataReader()
+	if err != nil {
+		return nil, err
 	}
+	return NewFromData(reader)
+}
 
-	// get path to textures files (already exported)
-	var textures []string
-	for _, v := range nd.Parent.SubNodes {
-		if v.Type == wad.NODE_TYPE_LINK {
-			v = v.LinkTo
+// Returns decoded materials of sibling nodes
+func siblingMaterials(nd *wad.WadNode) ([]*wad.WadNode, []*mat.Material, error) {
+	siblings := nd.Wad.Nodes
+	if nd.Parent != nil {
+		siblings = nd.Parent.SubNodes
+	}
+
+	nodes := make([]*wad.WadNode, 0)
+	mats := make([]*mat.Material, 0)
+	for _, v := range siblings {
+		if v = v.Resolve(); v == nil || v.Format != mat.MAT_MAGIC {
+			continue
 		}
-		if v.Format == mat.MAT_MAGIC {
-			if !v.Extracted || v.Cache == nil {
-				return errors.New("Material not loaded before mesh")
-			} else {
-				mat := v.Cache.(*mat.Material)
-				if mat == nil || mat.Layers == nil || len(mat.Layers) == 0 {
-					return fmt.Errorf("Material '%s' not cached ", v.Path)
-				}
 
-				if mat.Layers[0].Texture != "" {
-					t := nd.Find(mat.Layers[0].Texture, true)
-					if !t.Extracted || t.ExtractedNames == nil || len(t.ExtractedNames) == 0 {
-						return errors.New("Material not loaded before mesh")
-					} else {
-						tex := t.ExtractedNames[0]
-						texPath := path.Join(pathPrefix, tex)
-						textures = append(textures, texPath)
-					}
-				} else {
-					log.Printf("Mat without texture '%s'", v.Name)
-					textures = append(textures, "")
-				}
-			}
+		decoded, err := v.Decode()
+		if err != nil {
+			return nil, nil, fmt.Errorf("Material '%s' decoding error: %v", v.Path, err)
+		}
+		m := decoded.(*mat.Material)
+		if len(m.Layers) == 0 {
+			return nil, nil, fmt.Errorf("Material '%s' without layers", v.Path)
 		}
+		nodes = append(nodes, v)
+		mats = append(mats, m)
 	}
+	return nodes, mats, nil
+}
 
-	reader, err := nd.DataReader()
+// Textures of sibling materials used in obj file
+func (*ObjExporter) Dependencies(nd *wad.WadNode) ([]wad.Dependency, error) {
+	_, mats, err := siblingMaterials(nd)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	deps := make([]wad.Dependency, 0)
+	for _, m := range mats {
+		if m.Layers[0].Texture != "" {
+			deps = append(deps, wad.Dependency{Name: m.Layers[0].Texture})
+		}
+	}
+	return deps, nil
+}
+
+func (*ObjExporter) Export(nd *wad.WadNode, decoded interface{}, outfname string) error {
+	log.Printf("\n\nMesh '%s' extraction", nd.Name)
+
+	pathPrefix := "../"
+	for i := 0; i < nd.Depth; i++ {
+		pathPrefix += "../"
 	}
 
-	mesh, err := NewFromData(reader)
+	matnodes, mats, err := siblingMaterials(nd)
 	if err != nil {
 		return err
 	}
 
-	resNames, err := mesh.ExtractObj(textures, outfname)
+	// get path to textures files (already exported)
+	var textures []string
+	for i, m := range mats {
+		if m.Layers[0].Texture != "" {
+			t := nd.Find(m.Layers[0].Texture, true)
+			if t != nil {
+				t = t.Resolve()
+			}
+			if t == nil {
+				return fmt.Errorf("Texture '%s' not found", m.Layers[0].Texture)
+			} else if !t.Extracted() || len(t.ExtractedNames()) == 0 {
+				return errors.New("Material not loaded before mesh")
+			} else {
+				tex := t.ExtractedNames()[0]
+				texPath := path.Join(pathPrefix, tex)
+				textures = append(textures, texPath)
+			}
+		} else {
+			log.Printf("Mat without texture '%s'", matnodes[i].Name)
+			textures = append(textures, "")
+		}
+	}
+
+	resNames, err := decoded.(*Mesh).ExtractObj(textures, outfname)
 	if err != nil {
 		return err
 	}
 
-	nd.ExtractedNames = resNames
-	nd.Cache = mesh
+	nd.AddExtractedNames(resNames...)
 	return nil
 }
@@ -29,7 +29,7 @@ const OBJECT_MAGIC = 0x00040001
 const HEADER_SIZE = 0x2C
 
 func init() {
-	wad.PregisterExporter(OBJECT_MAGIC, &Object{})
+	wad.RegisterDecoder(utils.GAME_VERSION_GOW_1, OBJECT_MAGIC, &Object{})
 }
 
 func (j *Joint) String(prefix string) string {
@@ -102,18 +102,11 @@ func NewFromData(rdr io.ReaderAt) (*Object, error) {
 	return obj, nil
 }
 
-func (*Object) ExtractFromNode(nd *wad.WadNode, outfname string) error {
-	log.Printf("Obj '%s' extraction", nd.Path)
+func (*Object) Decode(nd *wad.WadNode) (interface{}, error) {
+	log.Printf("Obj '%s' decoding", nd.Path)
 	reader, err := nd.DataReader()
 	if err != nil {
-		return err
-	}
-
-	obj, err := NewFromData(reader)
-	if err != nil {
-		return err
+		return nil, err
 	}
-
-	nd.Cache = obj
-	return nil
+	return NewFromData(reader)
 }
@@ -0,0 +1,66 @@
+package pack
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mogaika/god_of_war_tools/files/tok"
+)
+
+// Selects files by name and pack number.
+// Empty filter (or nil) accepts all files
+type Filter struct {
+	Globs   []string // case insensitive, like "*.WAD" or "R_*"
+	Regexps []*regexp.Regexp
+	Packs   []uint32 // zero-based pack indexes
+}
+
+func (flt *Filter) matchName(name string) bool {
+	if len(flt.Globs) == 0 && len(flt.Regexps) == 0 {
+		return true
+	}
+
+	for _, glob := range flt.Globs {
+		if ok, _ := path.Match(strings.ToUpper(glob), strings.ToUpper(name)); ok {
+			return true
+		}
+	}
+	for _, re := range flt.Regexps {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (flt *Filter) AcceptPack(pack uint32) bool {
+	if flt == nil || len(flt.Packs) == 0 {
+		return true
+	}
+
+	for _, p := range flt.Packs {
+		if p == pack {
+			return true
+		}
+	}
+	return false
+}
+
+func (flt *Filter) matchPack(f *tok.File) bool {
+	for _, e := range f.Copies {
+		if flt.AcceptPack(e.Pack) {
+			return true
+		}
+	}
+	return false
+}
+
+// File matches if its name matches any of globs or regexps
+// and any of its copies stored in one of packs
+func (flt *Filter) Match(name string, f *tok.File) bool {
+	if flt == nil {
+		return true
+	}
+	return flt.matchName(name) && flt.matchPack(f)
+}
@@ -0,0 +1,261 @@
+package pack
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mogaika/god_of_war_tools/files/tok"
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+// Filesystem of files stored in part*.pak archives.
+// All files placed in root directory, first copy of file used for reading
+type FS struct {
+	src      fs.FS
+	tokfiles *tok.TokFile
+	packSize int64 // size after which files continue in next pack, 0 if files never cross packs
+
+	mutex sync.Mutex
+	packs map[uint32]packFile
+	sizes map[uint32]int64
+}
+
+// Version of game defines how files cross pack files:
+// GoW2 files continue in next pack after tok.SectorsInFile sectors,
+// GoW1 files never leave their pack
+func NewFS(src fs.FS, tokfiles *tok.TokFile, version int) *FS {
+	p := &FS{
+		src:      src,
+		tokfiles: tokfiles,
+		packs:    make(map[uint32]packFile),
+		sizes:    make(map[uint32]int64),
+	}
+	if version == utils.GAME_VERSION_GOW_2 {
+		p.packSize = PACK_SIZE_GOW2
+	}
+	return p
+}
+
+// Returns opened pack file and its size. Pack files stay opened until Close
+func (p *FS) pack(pack uint32) (packFile, int64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if pf, ok := p.packs[pack]; ok {
+		return pf, p.sizes[pack], nil
+	}
+
+	pf, err := openPack(p.src, pack)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stat, err := pf.Stat()
+	if err != nil {
+		pf.Close()
+		return nil, 0, err
+	}
+
+	p.packs[pack] = pf
+	p.sizes[pack] = stat.Size()
+	return pf, stat.Size(), nil
+}
+
+// Closes all opened pack files
+func (p *FS) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var err error
+	for pack, pf := range p.packs {
+		if cerr := pf.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(p.packs, pack)
+	}
+	return err
+}
+
+func (p *FS) lookup(op string, name string) (*tok.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if f, ok := p.tokfiles.Files[name]; ok {
+		return f, nil
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func (p *FS) Open(name string) (fs.File, error) {
+	if name == "." {
+		return &dir{fs: p}, nil
+	}
+
+	f, err := p.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return p.OpenCopy(f.Copies[0]), nil
+}
+
+// Opens specified copy of file
+func (p *FS) OpenCopy(e *tok.Entry) *File {
+	return &File{
+		SectionReader: io.NewSectionReader(&partedReader{
+			fs:    p,
+			pack:  e.Pack,
+			start: int64(e.StartSec) * utils.SectorSize,
+		}, 0, int64(e.Size)),
+		info: fileInfo{name: e.Name, file: p.tokfiles.Files[e.Name]},
+	}
+}
+
+func (p *FS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return dirInfo{}, nil
+	}
+
+	f, err := p.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{name: name, file: f}, nil
+}
+
+// Returns files sorted by name
+func (p *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name != "." {
+		if _, err := p.lookup("readdir", name); err != nil {
+			return nil, err
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	names := make([]string, 0, len(p.tokfiles.Files))
+	for name := range p.tokfiles.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]fs.DirEntry, len(names))
+	for i, name := range names {
+		entries[i] = fs.FileInfoToDirEntry(fileInfo{name: name, file: p.tokfiles.Files[name]})
+	}
+	return entries, nil
+}
+
+// Reads data which can continue in next pack files
+type partedReader struct {
+	fs    *FS
+	pack  uint32
+	start int64
+}
+
+func (pr *partedReader) ReadAt(b []byte, off int64) (int, error) {
+	pack := pr.pack
+	pos := pr.start + off
+	readed := 0
+
+	for readed < len(b) {
+		pf, _, err := pr.fs.pack(pack)
+		if err != nil {
+			return readed, err
+		}
+
+		chunk := b[readed:]
+		if packSize := pr.fs.packSize; packSize != 0 {
+			if pos >= packSize {
+				// data continues in next pack file
+				pos -= packSize
+				pack++
+				continue
+			}
+			if int64(len(chunk)) > packSize-pos {
+				chunk = chunk[:packSize-pos]
+			}
+		}
+
+		n, err := pf.ReadAt(chunk, pos)
+		readed += n
+		pos += int64(n)
+		if n < len(chunk) {
+			// pack file shorter than data of file
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return readed, err
+		}
+	}
+	return readed, nil
+}
+
+// Opened file, implements fs.File, io.ReaderAt and io.Seeker
+type File struct {
+	*io.SectionReader
+	info fileInfo
+}
+
+func (f *File) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *File) Close() error {
+	return nil
+}
+
+type fileInfo struct {
+	name string
+	file *tok.File
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return int64(fi.file.Size) }
+func (fi fileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() interface{}   { return fi.file }
+
+type dir struct {
+	fs      *FS
+	entries []fs.DirEntry
+	readed  bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return dirInfo{}, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.readed {
+		d.entries, _ = d.fs.ReadDir(".")
+		d.readed = true
+	}
+
+	entries := d.entries
+	if n > 0 {
+		if len(entries) == 0 {
+			return nil, io.EOF
+		}
+		if len(entries) > n {
+			entries = entries[:n]
+		}
+	}
+	d.entries = d.entries[len(entries):]
+	return entries, nil
+}
+
+type dirInfo struct{}
+
+func (dirInfo) Name() string       { return "." }
+func (dirInfo) Size() int64        { return 0 }
+func (dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (dirInfo) ModTime() time.Time { return time.Time{} }
+func (dirInfo) IsDir() bool        { return true }
+func (dirInfo) Sys() interface{}   { return nil }
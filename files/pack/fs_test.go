@@ -0,0 +1,106 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mogaika/god_of_war_tools/files/tok"
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+const testPackSize = 4 * utils.SectorSize
+
+func testData(size int, seed byte) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = seed + byte(i*7)
+	}
+	return data
+}
+
+// Pack files in memory, GoW2 pack size reduced to testPackSize
+func testFS(packs [][]byte, entries []*tok.Entry, version int) *FS {
+	src := make(fstest.MapFS)
+	for i, data := range packs {
+		src[PackFileName(uint32(i))] = &fstest.MapFile{Data: data}
+	}
+	tokfiles := tok.NewTokFile()
+	for _, e := range entries {
+		tokfiles.Add(e)
+	}
+	p := NewFS(src, tokfiles, version)
+	if p.packSize != 0 {
+		p.packSize = testPackSize
+	}
+	return p
+}
+
+func readAll(t *testing.T, p *FS, name string) ([]byte, error) {
+	f, err := p.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func TestReadCrossPack(t *testing.T) {
+	pack0, pack1 := testData(testPackSize, 1), testData(testPackSize, 2)
+	p := testFS([][]byte{pack0, pack1}, []*tok.Entry{
+		{Name: "A.WAD", Pack: 0, StartSec: 3, Size: 2*utils.SectorSize + 5},
+	}, utils.GAME_VERSION_GOW_2)
+	defer p.Close()
+
+	data, err := readAll(t, p, "A.WAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte{}, pack0[3*utils.SectorSize:]...), pack1[:utils.SectorSize+5]...)
+	if !bytes.Equal(data, want) {
+		t.Fatal("File crossing pack files readed wrong")
+	}
+}
+
+func TestReadShortPack(t *testing.T) {
+	pack0, pack1 := testData(2*utils.SectorSize, 1), testData(testPackSize, 2)
+	entries := []*tok.Entry{{Name: "A.WAD", Pack: 0, StartSec: 1, Size: 3 * utils.SectorSize}}
+
+	for _, version := range []int{utils.GAME_VERSION_GOW_1, utils.GAME_VERSION_GOW_2} {
+		p := testFS([][]byte{pack0, pack1}, entries, version)
+		if _, err := readAll(t, p, "A.WAD"); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Version %d: no unexpected eof error for short pack, got %v", version, err)
+		}
+		p.Close()
+	}
+}
+
+func TestVerifyShortPack(t *testing.T) {
+	pack0, pack1 := testData(2*utils.SectorSize, 1), testData(testPackSize, 2)
+	entries := []*tok.Entry{{Name: "A.WAD", Pack: 0, StartSec: 1, Size: 3 * utils.SectorSize}}
+
+	for _, version := range []int{utils.GAME_VERSION_GOW_1, utils.GAME_VERSION_GOW_2} {
+		p := testFS([][]byte{pack0, pack1}, entries, version)
+		problems := p.Verify(version)
+		if len(problems) != 1 || problems[0].Type != PROBLEM_TRUNCATED {
+			t.Errorf("Version %d: wrong problems for short pack %v", version, problems)
+		}
+		p.Close()
+	}
+}
+
+func TestVerifyCrossPack(t *testing.T) {
+	pack0, pack1 := testData(testPackSize, 1), testData(testPackSize, 1)
+	p := testFS([][]byte{pack0, pack1}, []*tok.Entry{
+		{Name: "A.WAD", Pack: 0, StartSec: 3, Size: 2 * utils.SectorSize},
+		{Name: "B.WAD", Pack: 1, StartSec: 3, Size: 2 * utils.SectorSize},
+	}, utils.GAME_VERSION_GOW_2)
+	defer p.Close()
+
+	problems := p.Verify(utils.GAME_VERSION_GOW_2)
+	if len(problems) != 1 || problems[0].Type != PROBLEM_TRUNCATED || problems[0].Entry.Name != "B.WAD" {
+		t.Errorf("Wrong problems %v", problems)
+	}
+}
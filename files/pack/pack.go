@@ -1,9 +1,10 @@
 package pack
 
 import (
+	"fmt"
 	"io"
+	"io/fs"
 	"log"
-	"math"
 	"os"
 	"path"
 	"strconv"
@@ -12,82 +13,97 @@ import (
 	"github.com/mogaika/god_of_war_tools/utils"
 )
 
+// Size of every GoW2 pack file except last one
+const PACK_SIZE_GOW2 = int64(tok.SectorsInFile) * utils.SectorSize
+
+func PackFileName(pack uint32) string {
+	return "part" + strconv.Itoa(int(pack+1)) + ".pak"
+}
+
 func getPackName(game_folder string, pack uint32) string {
-	return path.Join(game_folder, "part"+strconv.Itoa(int(pack+1))+".pak")
+	return path.Join(game_folder, PackFileName(pack))
 }
 
-func Unpack(game_folder string, out_folder string, tokfiles tok.TokFile, version int) (err error) {
+type packFile interface {
+	fs.File
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// Opens part*.pak file from game folder or disk image
+func openPack(src fs.FS, pack uint32) (packFile, error) {
+	f, err := src.Open(PackFileName(pack))
+	if err != nil {
+		return nil, err
+	}
+	if pf, ok := f.(packFile); ok {
+		return pf, nil
+	}
+	f.Close()
+	return nil, fmt.Errorf("Pack file '%s' is not seekable", PackFileName(pack))
+}
+
+// Unpacks files accepted by filter (all files if filter is nil)
+func Unpack(src fs.FS, out_folder string, tokfiles *tok.TokFile, version int, filter *Filter) (err error) {
 	os.MkdirAll(out_folder, 0666)
 
 	// Check pack* files for existing
 	packpresents := make(map[uint32]bool, 0)
-	for _, f := range tokfiles {
-		if _, ok := packpresents[f.Pack]; !ok {
-			if fl, err := os.Open(getPackName(game_folder, f.Pack)); err == nil {
-				packpresents[f.Pack] = true
+	for _, e := range tokfiles.Entries {
+		if _, ok := packpresents[e.Pack]; !ok {
+			if fl, err := src.Open(PackFileName(e.Pack)); err == nil {
+				packpresents[e.Pack] = true
 				fl.Close()
 			} else {
-				packpresents[f.Pack] = false
+				packpresents[e.Pack] = false
 			}
 		}
 	}
 
-	var curpart uint32 = math.MaxUint32
-	var disk *os.File
+	packfs := NewFS(src, tokfiles, tokfiles.Version)
+	defer packfs.Close()
 
-	defer func() {
-		if curpart != math.MaxUint32 {
-			disk.Close()
+	names := make([]string, 0)
+	for _, name := range tokfiles.Names(version) {
+		if filter.Match(name, tokfiles.Files[name]) {
+			names = append(names, name)
 		}
-	}()
-
-	i := 0
-	for name, f := range tokfiles {
-		i++
-		if packpresents[f.Pack] {
-			if f.Pack != curpart {
-				newdisk, err := os.Open(getPackName(game_folder, f.Pack))
-				if err != nil {
-					return err
-				}
-
-				disk.Close()
-				curpart = f.Pack
-				disk = newdisk
-			}
+	}
 
-			fo, err := os.Create(path.Join(out_folder, name))
-			if err != nil {
-				return err
+	for i, name := range names {
+		// take first copy from presented and accepted pack
+		var e *tok.Entry
+		for _, c := range tokfiles.Files[name].Copies {
+			if packpresents[c.Pack] && filter.AcceptPack(c.Pack) {
+				e = c
+				break
 			}
-			defer fo.Close()
-
-			disk.Seek(int64(f.StartSec*utils.SectorSize), os.SEEK_SET)
+		}
+		if e == nil {
+			continue
+		}
 
-			log.Printf("[%.4d/%.4d] Unpaking (pk: %v beg:%.8x sz:%.8x) %s \n", i, len(tokfiles), f.Pack+1, f.StartSec*utils.SectorSize, f.Size, name)
+		log.Printf("[%.4d/%.4d] Unpaking (pk: %v beg:%.8x sz:%.8x) %s \n", i+1, len(names), e.Pack+1, e.StartSec*utils.SectorSize, e.Size, name)
 
-			wrtd, err := io.CopyN(fo, disk, int64(f.Size))
-			if err == io.EOF && wrtd != int64(f.Size) {
-				log.Printf("Parted file: %.8x size: %.8x file: %#v \n", wrtd, f.Size, f)
-				curpart++
+		if err := unpackFile(packfs.OpenCopy(e), path.Join(out_folder, name)); err != nil {
+			return err
+		}
+	}
 
-				disk.Close()
-				disk, err = os.Open(getPackName(game_folder, f.Pack))
-				if err != nil {
-					return err
-				}
+	return nil
+}
 
-				next_wrtd, err := io.CopyN(fo, disk, int64(f.Size)-wrtd)
-				if err != nil || (err == io.EOF && (next_wrtd+wrtd) != int64(f.Size)) {
-					return err
-				}
-			} else if err != nil {
-				return err
-			}
+func unpackFile(fin *File, outfname string) error {
+	defer fin.Close()
 
-			fo.Close()
-		}
+	fo, err := os.Create(outfname)
+	if err != nil {
+		return err
 	}
+	defer fo.Close()
 
-	return nil
+	if _, err := io.Copy(fo, fin); err != nil {
+		return err
+	}
+	return fo.Close()
 }
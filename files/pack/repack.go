@@ -0,0 +1,188 @@
+package pack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/mogaika/god_of_war_tools/files/tok"
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+// Writes part*.pak files to out_folder using files from in_folder.
+// Places copies of files in order of their original positions and returns new locations
+func packFiles(in_folder string, out_folder string, tokfiles *tok.TokFile, version int, keepDups bool) ([]*tok.Entry, error) {
+	result := make([]*tok.Entry, 0, len(tokfiles.Entries))
+
+	var disk *os.File
+	curpart := uint32(0)
+	curpos := int64(0)
+
+	defer func() {
+		if disk != nil {
+			disk.Close()
+		}
+	}()
+
+	openPart := func(pack uint32) (err error) {
+		if disk != nil {
+			if err = disk.Close(); err != nil {
+				return err
+			}
+		}
+		disk, err = os.Create(getPackName(out_folder, pack))
+		curpart = pack
+		curpos = 0
+		return err
+	}
+
+	if err := openPart(0); err != nil {
+		return nil, err
+	}
+
+	padding := make([]byte, utils.SectorSize)
+
+	// without keepDups only first copy of every file placed
+	places := make([]*tok.Entry, 0, len(tokfiles.Entries))
+	for _, f := range tokfiles.Files {
+		if keepDups {
+			places = append(places, f.Copies...)
+		} else {
+			places = append(places, f.Copies[0])
+		}
+	}
+	sort.Slice(places, func(i, j int) bool {
+		pi, pj := places[i].Position(version), places[j].Position(version)
+		if pi != pj {
+			return pi < pj
+		}
+		return places[i].Name < places[j].Name
+	})
+
+	for i, place := range places {
+		name := place.Name
+
+		fin, err := os.Open(path.Join(in_folder, name))
+		if err != nil {
+			return nil, err
+		}
+
+		err = func() error {
+			defer fin.Close()
+
+			stat, err := fin.Stat()
+			if err != nil {
+				return err
+			}
+			if stat.Size() > math.MaxUint32 {
+				return fmt.Errorf("File '%s' is too big for packing", name)
+			}
+			size := uint32(stat.Size())
+
+			switch version {
+			case utils.GAME_VERSION_GOW_1:
+				// GoW1 stores pack index for every copy, so keep copies in their original packs
+				if place.Pack != curpart {
+					if place.Pack < curpart {
+						return errors.New("Files not sorted by pack")
+					}
+					if err := openPart(place.Pack); err != nil {
+						return err
+					}
+				}
+			case utils.GAME_VERSION_GOW_2:
+				if curpos == PACK_SIZE_GOW2 {
+					if err := openPart(curpart + 1); err != nil {
+						return err
+					}
+				}
+			}
+
+			pf := &tok.Entry{
+				Name:     name,
+				Pack:     curpart,
+				Size:     size,
+				StartSec: uint32(curpos / utils.SectorSize),
+				Index:    -1,
+			}
+
+			log.Printf("[%.4d/%.4d] Packing (pk: %v beg:%.8x sz:%.8x) %s \n",
+				i+1, len(places), pf.Pack+1, curpos, pf.Size, name)
+
+			left := int64(pf.Size)
+			for {
+				chunk := left
+				if version == utils.GAME_VERSION_GOW_2 && chunk > PACK_SIZE_GOW2-curpos {
+					// file parted between two pack files
+					chunk = PACK_SIZE_GOW2 - curpos
+				}
+
+				if _, err := io.CopyN(disk, fin, chunk); err != nil {
+					return err
+				}
+				curpos += chunk
+				left -= chunk
+
+				if left == 0 {
+					break
+				}
+				log.Printf("Parted file: %.8x size: %.8x file: %s \n", int64(pf.Size)-left, pf.Size, name)
+				if err := openPart(curpart + 1); err != nil {
+					return err
+				}
+			}
+
+			if tail := curpos % utils.SectorSize; tail != 0 {
+				n, err := disk.Write(padding[:utils.SectorSize-tail])
+				if err != nil {
+					return err
+				}
+				curpos += int64(n)
+			}
+
+			result = append(result, pf)
+			return nil
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := disk.Close()
+	disk = nil
+	return result, err
+}
+
+// Creates part*.pak files and GODOFWAR.TOC in out_folder, using
+// files from in_folder and layout from original tok file.
+// If keepDups is set, every file copied as many times as in original tok
+func Pack(in_folder string, out_folder string, tokfiles *tok.TokFile, version int, keepDups bool) error {
+	if err := os.MkdirAll(out_folder, 0777); err != nil {
+		return err
+	}
+
+	if version != utils.GAME_VERSION_GOW_1 && version != utils.GAME_VERSION_GOW_2 {
+		return errors.New("Unknown tok version for packing")
+	}
+
+	files, err := packFiles(in_folder, out_folder, tokfiles, version, keepDups)
+	if err != nil {
+		return err
+	}
+
+	ftok, err := os.Create(path.Join(out_folder, tok.TOK_FILE_NAME))
+	if err != nil {
+		return err
+	}
+	defer ftok.Close()
+
+	if err := tok.EncodeEntries(ftok, files, version); err != nil {
+		return err
+	}
+	return ftok.Close()
+}
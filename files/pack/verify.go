@@ -0,0 +1,115 @@
+package pack
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/mogaika/god_of_war_tools/files/tok"
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+const (
+	PROBLEM_MISSING_PACK = iota
+	PROBLEM_TRUNCATED
+	PROBLEM_COPY_DIFFERS
+	PROBLEM_READ_ERROR
+)
+
+// Problem found in copy of file
+type Problem struct {
+	Type    int // PROBLEM_*
+	Entry   *tok.Entry
+	Message string
+}
+
+func (p *Problem) String() string {
+	return fmt.Sprintf("'%s' (idx: %d pk: %v beg:%.8x sz:%.8x): %s",
+		p.Entry.Name, p.Entry.Index, p.Entry.Pack+1, int64(p.Entry.StartSec)*utils.SectorSize, p.Entry.Size, p.Message)
+}
+
+// Checks that copy fits into pack files. GoW2 copy can continue in next
+// pack files, every pack before last must have full size then
+func (p *FS) checkBounds(e *tok.Entry) *Problem {
+	pack := e.Pack
+	pos := int64(e.StartSec) * utils.SectorSize
+	left := int64(e.Size)
+
+	for {
+		_, size, err := p.pack(pack)
+		if err != nil {
+			if pack == e.Pack {
+				return &Problem{Type: PROBLEM_MISSING_PACK, Entry: e,
+					Message: fmt.Sprintf("pack file %s not opened: %v", PackFileName(pack), err)}
+			}
+			return &Problem{Type: PROBLEM_TRUNCATED, Entry: e,
+				Message: fmt.Sprintf("0x%x bytes continues in missing %s", left, PackFileName(pack))}
+		}
+
+		end := pos + left
+		if p.packSize != 0 && end > p.packSize {
+			end = p.packSize
+		}
+		if end > size {
+			return &Problem{Type: PROBLEM_TRUNCATED, Entry: e,
+				Message: fmt.Sprintf("%s truncated, size 0x%x, expected at least 0x%x", PackFileName(pack), size, end)}
+		}
+		if end == pos+left {
+			return nil
+		}
+
+		left -= end - pos
+		pos = 0
+		pack++
+	}
+}
+
+func (p *FS) hashCopy(e *tok.Entry) ([]byte, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, p.OpenCopy(e)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Checks every copy of every file: presence of pack file,
+// truncation at the end of pack files and equality of copies
+func (p *FS) Verify(version int) []*Problem {
+	tokfiles := p.tokfiles
+	problems := make([]*Problem, 0)
+
+	names := tokfiles.Names(version)
+	for i, name := range names {
+		f := tokfiles.Files[name]
+		log.Printf("[%.4d/%.4d] Verifying %s (copies: %d)", i+1, len(names), name, len(f.Copies))
+
+		var etalon *tok.Entry
+		var etalonHash []byte
+
+		for _, e := range f.Copies {
+			if problem := p.checkBounds(e); problem != nil {
+				problems = append(problems, problem)
+				continue
+			}
+
+			hash, err := p.hashCopy(e)
+			if err != nil {
+				problems = append(problems, &Problem{Type: PROBLEM_READ_ERROR, Entry: e, Message: err.Error()})
+				continue
+			}
+
+			if etalon == nil {
+				etalon = e
+				etalonHash = hash
+			} else if e.Size != etalon.Size || !bytes.Equal(hash, etalonHash) {
+				problems = append(problems, &Problem{Type: PROBLEM_COPY_DIFFERS, Entry: e,
+					Message: fmt.Sprintf("differs from copy idx: %d pk: %v beg:%.8x",
+						etalon.Index, etalon.Pack+1, int64(etalon.StartSec)*utils.SectorSize)})
+			}
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,297 @@
+package pss
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mogaika/god_of_war_tools/files/vag"
+	"github.com/mogaika/god_of_war_tools/utils/wav"
+)
+
+// MPEG program stream start codes
+const (
+	CODE_PACK_HEADER   = 0xba
+	CODE_SYSTEM_HEADER = 0xbb
+	CODE_PROGRAM_END   = 0xb9
+	STREAM_PRIVATE_1   = 0xbd
+	STREAM_PADDING     = 0xbe
+	STREAM_PRIVATE_2   = 0xbf
+	STREAM_VIDEO_FIRST = 0xe0
+	STREAM_VIDEO_LAST  = 0xef
+)
+
+// Audio codecs in SShd header
+const (
+	AUDIO_CODEC_PCM16LE = 0x01
+	AUDIO_CODEC_ADPCM   = 0x10
+)
+
+// Called for every PES packet payload. For private stream 1
+// subStream is first byte of payload and not included into payload
+type PacketHandler func(stream byte, subStream byte, payload []byte) error
+
+func isVideo(stream byte) bool {
+	return stream >= STREAM_VIDEO_FIRST && stream <= STREAM_VIDEO_LAST
+}
+
+// Reads next start code, skipping garbage between packets
+func nextStartCode(r *bufio.Reader) (byte, error) {
+	var code uint32 = 0xffffffff
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<8 | uint32(b)
+		if code&0xffffff00 == 0x00000100 {
+			return b, nil
+		}
+	}
+}
+
+// Skips MPEG-1 or MPEG-2 PES header. Returns payload
+func pesPayload(packet []byte) ([]byte, error) {
+	if len(packet) == 0 {
+		return packet, nil
+	}
+
+	if packet[0]&0xc0 == 0x80 {
+		// MPEG-2
+		if len(packet) < 3 || 3+int(packet[2]) > len(packet) {
+			return nil, errors.New("Corrupted MPEG-2 PES header")
+		}
+		return packet[3+int(packet[2]):], nil
+	}
+
+	// MPEG-1
+	pos := 0
+	for pos < len(packet) && packet[pos] == 0xff {
+		pos++ // stuffing
+	}
+	if pos < len(packet) && packet[pos]&0xc0 == 0x40 {
+		pos += 2 // STD buffer
+	}
+	if pos >= len(packet) {
+		return nil, errors.New("Corrupted MPEG-1 PES header")
+	}
+	switch packet[pos] & 0xf0 {
+	case 0x20:
+		pos += 5 // PTS
+	case 0x30:
+		pos += 10 // PTS + DTS
+	default:
+		pos++
+	}
+	if pos > len(packet) {
+		return nil, errors.New("Corrupted MPEG-1 PES header")
+	}
+	return packet[pos:], nil
+}
+
+// Walks program stream packs and calls handler for every PES packet
+func Demux(r io.Reader, handler PacketHandler) error {
+	br := bufio.NewReader(r)
+	var lenbuf [2]byte
+
+	for {
+		code, err := nextStartCode(br)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch {
+		case code == CODE_PROGRAM_END:
+			return nil
+		case code == CODE_PACK_HEADER:
+			hdr, err := br.Peek(10)
+			if err != nil {
+				return err
+			}
+			if hdr[0]&0xc0 == 0x40 {
+				// MPEG-2: 10 bytes + stuffing
+				if _, err := br.Discard(10 + int(hdr[9]&7)); err != nil {
+					return err
+				}
+			} else {
+				// MPEG-1: 8 bytes
+				if _, err := br.Discard(8); err != nil {
+					return err
+				}
+			}
+		case code >= CODE_SYSTEM_HEADER:
+			if _, err := io.ReadFull(br, lenbuf[:]); err != nil {
+				return err
+			}
+			packet := make([]byte, binary.BigEndian.Uint16(lenbuf[:]))
+			if _, err := io.ReadFull(br, packet); err != nil {
+				return err
+			}
+
+			if code == CODE_SYSTEM_HEADER || code == STREAM_PADDING || code == STREAM_PRIVATE_2 {
+				continue
+			}
+
+			payload, err := pesPayload(packet)
+			if err != nil {
+				return err
+			}
+
+			var subStream byte
+			if code == STREAM_PRIVATE_1 {
+				if len(payload) == 0 {
+					continue
+				}
+				subStream = payload[0]
+				payload = payload[1:]
+			}
+
+			if err := handler(code, subStream, payload); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Audio stream with SShd header
+type Audio struct {
+	SubStream  byte
+	Codec      uint32 // AUDIO_CODEC_*
+	SampleRate uint32
+	Channels   uint32
+	Interleave uint32
+	LoopStart  uint32
+	LoopEnd    uint32
+	Data       []byte
+}
+
+func newAudio(subStream byte, stream []byte) (*Audio, error) {
+	if len(stream) < 0x28 || string(stream[0:4]) != "SShd" || string(stream[0x20:0x24]) != "SSbd" {
+		return nil, errors.New("Audio stream without SShd/SSbd header")
+	}
+
+	a := &Audio{
+		SubStream:  subStream,
+		Codec:      binary.LittleEndian.Uint32(stream[0x8:0xc]),
+		SampleRate: binary.LittleEndian.Uint32(stream[0xc:0x10]),
+		Channels:   binary.LittleEndian.Uint32(stream[0x10:0x14]),
+		Interleave: binary.LittleEndian.Uint32(stream[0x14:0x18]),
+		LoopStart:  binary.LittleEndian.Uint32(stream[0x18:0x1c]),
+		LoopEnd:    binary.LittleEndian.Uint32(stream[0x1c:0x20]),
+		Data:       stream[0x28:],
+	}
+	if size := binary.LittleEndian.Uint32(stream[0x24:0x28]); int(size) < len(a.Data) {
+		a.Data = a.Data[:size]
+	}
+
+	if a.Channels == 0 || a.SampleRate == 0 {
+		return nil, fmt.Errorf("Wrong audio channels count %d or sample rate %d", a.Channels, a.SampleRate)
+	}
+	return a, nil
+}
+
+func (a *Audio) Wav() (*wav.Wav, error) {
+	w := &wav.Wav{
+		Channels:   int(a.Channels),
+		SampleRate: int(a.SampleRate),
+	}
+
+	switch a.Codec {
+	case AUDIO_CODEC_ADPCM:
+		if a.Interleave == 0 || a.Interleave%vag.FRAME_SIZE != 0 {
+			return nil, fmt.Errorf("Wrong ADPCM interleave 0x%x", a.Interleave)
+		}
+		w.Samples = vag.DecodeInterleaved(a.Data, int(a.Channels), int(a.Interleave))
+	case AUDIO_CODEC_PCM16LE:
+		samples := make([]int16, len(a.Data)/2)
+		if a.Channels == 1 || a.Interleave == 0 {
+			for i := range samples {
+				samples[i] = int16(binary.LittleEndian.Uint16(a.Data[i*2:]))
+			}
+		} else {
+			// blocks of interleave bytes for every channel
+			block := int(a.Interleave) / 2
+			channels := int(a.Channels)
+			frames := len(samples) / channels / block * block
+			samples = samples[:frames*channels]
+			for i := range samples {
+				ch := (i / block) % channels
+				pos := (i/(block*channels))*block + i%block
+				samples[pos*channels+ch] = int16(binary.LittleEndian.Uint16(a.Data[i*2:]))
+			}
+		}
+		w.Samples = samples
+	default:
+		return nil, fmt.Errorf("Unknown audio codec 0x%x", a.Codec)
+	}
+	return w, nil
+}
+
+// Result of demuxing
+type Pss struct {
+	VideoStreams []byte // stream ids in order of appearance
+	Audio        []*Audio
+}
+
+// Writes video elementary streams to writer returned by video
+// callback and collects audio streams
+func Split(r io.Reader, video func(stream byte) (io.Writer, error)) (*Pss, error) {
+	pss := &Pss{VideoStreams: make([]byte, 0), Audio: make([]*Audio, 0)}
+
+	videoWriters := make(map[byte]io.Writer)
+	audioStreams := make(map[byte]*bytes.Buffer)
+	audioOrder := make([]byte, 0)
+	// size of private header between substream id and audio data
+	audioSkip := make(map[byte]int)
+
+	err := Demux(r, func(stream byte, subStream byte, payload []byte) error {
+		switch {
+		case isVideo(stream):
+			w, ok := videoWriters[stream]
+			if !ok {
+				var err error
+				if w, err = video(stream); err != nil {
+					return err
+				}
+				videoWriters[stream] = w
+				pss.VideoStreams = append(pss.VideoStreams, stream)
+			}
+			_, err := w.Write(payload)
+			return err
+		case stream == STREAM_PRIVATE_1:
+			buf, ok := audioStreams[subStream]
+			if !ok {
+				// first packet contains header, which shows size of private header
+				skip := bytes.Index(payload, []byte("SShd"))
+				if skip < 0 || skip > 8 {
+					return nil
+				}
+				buf = &bytes.Buffer{}
+				audioStreams[subStream] = buf
+				audioSkip[subStream] = skip
+				audioOrder = append(audioOrder, subStream)
+			}
+			if skip := audioSkip[subStream]; skip <= len(payload) {
+				buf.Write(payload[skip:])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, subStream := range audioOrder {
+		a, err := newAudio(subStream, audioStreams[subStream].Bytes())
+		if err != nil {
+			return nil, err
+		}
+		pss.Audio = append(pss.Audio, a)
+	}
+	return pss, nil
+}
@@ -0,0 +1,124 @@
+package pss
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func packHeader2(stuffing int) []byte {
+	hdr := []byte{0, 0, 1, CODE_PACK_HEADER, 0x44, 0, 4, 0, 4, 1, 0x01, 0x89, 0xc3, 0xf8 | byte(stuffing)}
+	return append(hdr, bytes.Repeat([]byte{0xff}, stuffing)...)
+}
+
+func packHeader1() []byte {
+	return []byte{0, 0, 1, CODE_PACK_HEADER, 0x21, 0, 1, 0, 1, 0x80, 0x1b, 0x83}
+}
+
+func pes(stream byte, header []byte, payload []byte) []byte {
+	packet := append(append([]byte{}, header...), payload...)
+	res := []byte{0, 0, 1, stream, 0, 0}
+	binary.BigEndian.PutUint16(res[4:], uint16(len(packet)))
+	return append(res, packet...)
+}
+
+// MPEG-2 PES header with PTS
+var pesHeader2 = []byte{0x81, 0x80, 0x05, 0x21, 0x00, 0x01, 0x00, 0x01}
+
+// Stereo PCM16 stream interleaved by 2 samples, with one incomplete block at end
+func audioStream() []byte {
+	data := make([]byte, 0)
+	for block := 0; block < 4; block++ {
+		for i := 0; i < 2; i++ {
+			v := int16(100 + block/2*2 + i)
+			if block%2 == 1 {
+				v = -v
+			}
+			data = append(data, byte(v), byte(uint16(v)>>8))
+		}
+	}
+	data = append(data, 0x55, 0x55)
+
+	hdr := make([]byte, 0x28)
+	copy(hdr, "SShd")
+	binary.LittleEndian.PutUint32(hdr[0x4:], 0x18)
+	binary.LittleEndian.PutUint32(hdr[0x8:], AUDIO_CODEC_PCM16LE)
+	binary.LittleEndian.PutUint32(hdr[0xc:], 48000)
+	binary.LittleEndian.PutUint32(hdr[0x10:], 2)
+	binary.LittleEndian.PutUint32(hdr[0x14:], 4)
+	binary.LittleEndian.PutUint32(hdr[0x18:], 0xffffffff)
+	binary.LittleEndian.PutUint32(hdr[0x1c:], 0xffffffff)
+	copy(hdr[0x20:], "SSbd")
+	binary.LittleEndian.PutUint32(hdr[0x24:], uint32(len(data)))
+	return append(hdr, data...)
+}
+
+func pssFixture() []byte {
+	audio := audioStream()
+	// substream id and private header before audio data
+	private := []byte{0x00, 0xaa, 0xbb, 0xcc}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0x12, 0x34}) // garbage before first pack
+	buf.Write(packHeader2(3))
+	buf.Write(pes(CODE_SYSTEM_HEADER, nil, []byte{0x80, 0x01, 0x02, 0x03, 0x04, 0x05}))
+	buf.Write(pes(STREAM_VIDEO_FIRST, pesHeader2, []byte("VIDEO1")))
+	buf.Write(pes(STREAM_PRIVATE_1, pesHeader2, append(append([]byte{}, private...), audio[:0x30]...)))
+	buf.Write(packHeader1())
+	buf.Write(pes(STREAM_PADDING, nil, bytes.Repeat([]byte{0xff}, 8)))
+	// MPEG-1 PES header with stuffing and PTS
+	buf.Write(pes(STREAM_VIDEO_FIRST, []byte{0xff, 0xff, 0x21, 0x00, 0x01, 0x00, 0x01}, []byte("VIDEO2")))
+	buf.Write(pes(STREAM_PRIVATE_1, pesHeader2, append(append([]byte{}, private...), audio[0x30:]...)))
+	buf.Write([]byte{0, 0, 1, CODE_PROGRAM_END})
+	return buf.Bytes()
+}
+
+func TestSplit(t *testing.T) {
+	videos := make(map[byte]*bytes.Buffer)
+	pss, err := Split(bytes.NewReader(pssFixture()), func(stream byte) (io.Writer, error) {
+		videos[stream] = &bytes.Buffer{}
+		return videos[stream], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pss.VideoStreams) != 1 || pss.VideoStreams[0] != STREAM_VIDEO_FIRST {
+		t.Fatalf("Wrong video streams %v", pss.VideoStreams)
+	}
+	if got := videos[STREAM_VIDEO_FIRST].String(); got != "VIDEO1VIDEO2" {
+		t.Fatalf("Wrong video stream %q", got)
+	}
+
+	if len(pss.Audio) != 1 {
+		t.Fatalf("Wrong audio streams count %d", len(pss.Audio))
+	}
+	a := pss.Audio[0]
+	if a.SubStream != 0 || a.Codec != AUDIO_CODEC_PCM16LE || a.SampleRate != 48000 || a.Channels != 2 || a.Interleave != 4 {
+		t.Fatalf("Wrong audio header %+v", a)
+	}
+	if !bytes.Equal(a.Data, audioStream()[0x28:]) {
+		t.Fatal("Audio data differs")
+	}
+
+	w, err := a.Wav()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int16{100, -100, 101, -101, 102, -102, 103, -103}
+	if len(w.Samples) != len(want) {
+		t.Fatalf("Wrong samples %v, want %v", w.Samples, want)
+	}
+	for i := range want {
+		if w.Samples[i] != want[i] {
+			t.Fatalf("Wrong samples %v, want %v", w.Samples, want)
+		}
+	}
+}
+
+func TestAudioWithoutHeader(t *testing.T) {
+	if _, err := newAudio(0, make([]byte, 0x40)); err == nil {
+		t.Fatal("No error for audio stream without SShd header")
+	}
+}
@@ -0,0 +1,77 @@
+package sanity
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const FILE_NAME = "SANITY.TXT"
+
+// File mentioned in SANITY.TXT
+type Record struct {
+	Name string
+	Size int64 // -1 if size not presented
+	Line int
+}
+
+type Sanity struct {
+	Records []*Record
+	Unknown []string // lines which not looks like file records
+}
+
+func parseNumber(s string) (int64, bool) {
+	v, err := strconv.ParseInt(s, 0, 64)
+	return v, err == nil && v >= 0
+}
+
+func looksLikeFileName(s string) bool {
+	dot := strings.LastIndexByte(s, '.')
+	return dot > 0 && dot < len(s)-1 && len(s) <= 24
+}
+
+// Layout of SANITY.TXT is not documented and was not checked against
+// real disc, so parser is tolerant guess: text lines in form "NAME.EXT [size]",
+// separated by spaces, tabs or commas. Empty lines and comments (#, ;, //)
+// are skipped. Other lines stored in Unknown, file without any
+// recognised record must be treated as unsupported by caller
+func NewFromData(r io.Reader) (*Sanity, error) {
+	san := &Sanity{
+		Records: make([]*Record, 0),
+		Unknown: make([]string, 0),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for iLine := 1; scanner.Scan(); iLine++ {
+		line := strings.TrimSpace(strings.TrimRight(scanner.Text(), "\x00"))
+		if line == "" || strings.HasPrefix(line, "#") ||
+			strings.HasPrefix(line, ";") || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ','
+		})
+
+		if len(fields) == 0 || !looksLikeFileName(fields[0]) {
+			san.Unknown = append(san.Unknown, line)
+			continue
+		}
+
+		rec := &Record{
+			Name: strings.ToUpper(fields[0]),
+			Size: -1,
+			Line: iLine,
+		}
+		for _, f := range fields[1:] {
+			if size, ok := parseNumber(f); ok {
+				rec.Size = size
+				break
+			}
+		}
+		san.Records = append(san.Records, rec)
+	}
+
+	return san, scanner.Err()
+}
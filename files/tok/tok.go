@@ -3,21 +3,123 @@ package tok
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"sort"
 
 	"github.com/mogaika/god_of_war_tools/utils"
 )
 
+const TOK_FILE_NAME = "GODOFWAR.TOC"
+
+// GoW2 addresses files by global sector, which is split into
+// part*.pak files of this many sectors each
+const SectorsInFile = (0x3FFFF800 / utils.SectorSize)
+
+// Location of one copy of file in part*.pak archives
+type Entry struct {
+	Name     string
+	Pack     uint32
+	Size     uint32
+	StartSec uint32
+	Index    int // index of record in tok file, -1 for new entries
+}
+
+// File with all its copies. Pack, Size and StartSec describe first copy
 type File struct {
 	Pack     uint32
 	Size     uint32
 	StartSec uint32
-	Count    int
+	Count    int // count of duplicates (copies except first)
+	Copies   []*Entry
+}
+
+type TokFile struct {
+	Entries []*Entry // all records in order of tok file
+	Files   map[string]*File
+	Trailer []byte // bytes after end of table (padding), written back by Encode
+	Version int    // version of game, set by Decode
+}
+
+func NewTokFile() *TokFile {
+	return &TokFile{
+		Entries: make([]*Entry, 0),
+		Files:   make(map[string]*File),
+	}
+}
+
+// Appends copy of file to table
+func (tf *TokFile) Add(e *Entry) {
+	tf.Entries = append(tf.Entries, e)
+
+	if f, ok := tf.Files[e.Name]; ok {
+		f.Count++
+		f.Copies = append(f.Copies, e)
+		if f.Size != e.Size {
+			log.Printf("File is not copy %s\n", e.Name)
+		}
+	} else {
+		tf.Files[e.Name] = &File{
+			Pack:     e.Pack,
+			Size:     e.Size,
+			StartSec: e.StartSec,
+			Copies:   []*Entry{e},
+		}
+	}
 }
 
-type TokFile map[string]*File
+func globalPos(pack uint32, startSec uint32, version int) uint64 {
+	if version == utils.GAME_VERSION_GOW_2 {
+		return uint64(pack)*SectorsInFile + uint64(startSec)
+	}
+	return uint64(pack)<<32 + uint64(startSec)
+}
+
+// Position of entry in archives, comparable between entries of same version
+func (e *Entry) Position(version int) uint64 {
+	return globalPos(e.Pack, e.StartSec, version)
+}
+
+// Returns copy of file closest to given position in archives
+func (f *File) Nearest(pack uint32, sec uint32, version int) *Entry {
+	var nearest *Entry
+	var nearestDist uint64
+	pos := globalPos(pack, sec, version)
+	for _, e := range f.Copies {
+		dist := globalPos(e.Pack, e.StartSec, version)
+		if dist > pos {
+			dist -= pos
+		} else {
+			dist = pos - dist
+		}
+		if nearest == nil || dist < nearestDist {
+			nearest = e
+			nearestDist = dist
+		}
+	}
+	return nearest
+}
+
+// Returns names of files in order of their first copy position in archives
+func (tf *TokFile) Names(version int) []string {
+	names := make([]string, 0, len(tf.Files))
+	for name := range tf.Files {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		fi, fj := tf.Files[names[i]], tf.Files[names[j]]
+		pi, pj := globalPos(fi.Pack, fi.StartSec, version), globalPos(fj.Pack, fj.StartSec, version)
+		if pi != pj {
+			return pi < pj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
 
 func DetectVersion(tokfile io.Reader) (int, error) {
 	buffer := make([]byte, 4)
@@ -42,12 +144,12 @@ func DetectVersion(tokfile io.Reader) (int, error) {
 }
 
 // GOF 1
-func parseTok1(file io.Reader) (TokFile, error) {
+func parseTok1(file io.Reader) (*TokFile, error) {
 	buffer := make([]byte, 24)
-	files := make(TokFile, 0)
+	files := NewTokFile()
 
 	for {
-		_, err := file.Read(buffer)
+		_, err := io.ReadFull(file, buffer)
 		if err == io.EOF {
 			break
 		} else if err != nil {
@@ -56,90 +158,194 @@ func parseTok1(file io.Reader) (TokFile, error) {
 
 		name := utils.BytesToString(buffer[0:12])
 		if name == "" {
+			// empty name ends table
+			files.Trailer, err = ioutil.ReadAll(file)
+			if err != nil {
+				return nil, err
+			}
 			break
 		}
 
-		file := &File{
+		files.Add(&Entry{
+			Name:     name,
 			Pack:     binary.LittleEndian.Uint32(buffer[12:16]),
 			Size:     binary.LittleEndian.Uint32(buffer[16:20]),
-			StartSec: binary.LittleEndian.Uint32(buffer[20:24])}
-
-		if _, ok := files[name]; ok {
-			files[name].Count++
-			if files[name].Size != file.Size {
-				log.Printf("File is not copy %s\n", name)
-			}
-		} else {
-			files[name] = file
-		}
+			StartSec: binary.LittleEndian.Uint32(buffer[20:24]),
+			Index:    len(files.Entries),
+		})
 	}
 
 	return files, nil
 }
 
 // GOF 2
-func parseTok2(file io.Reader) (TokFile, error) {
-	const SectorsInFile = (0x3FFFF800 / utils.SectorSize)
+func parseTok2(file io.Reader) (*TokFile, error) {
+	type record struct {
+		name   string
+		size   uint32
+		copies uint32
+		posidx uint32
+	}
 
 	buffer := make([]byte, 4)
 
-	_, err := file.Read(buffer)
+	_, err := io.ReadFull(file, buffer)
 	if err != nil {
 		return nil, err
 	}
 
 	fcount := binary.LittleEndian.Uint32(buffer)
-	maxindex := uint32(0)
 
 	buffer = make([]byte, 36)
-	files := make(TokFile)
+	records := make([]record, fcount)
 
-	for i := uint32(0); i < fcount; i++ {
-		_, err := file.Read(buffer)
+	for i := range records {
+		_, err := io.ReadFull(file, buffer)
 		if err != nil {
 			return nil, err
 		}
 
-		name := utils.BytesToString(buffer[0:24])
-		file := &File{
-			Size:     binary.LittleEndian.Uint32(buffer[24:28]),
-			StartSec: binary.LittleEndian.Uint32(buffer[32:36])}
+		records[i] = record{
+			name:   utils.BytesToString(buffer[0:24]),
+			size:   binary.LittleEndian.Uint32(buffer[24:28]),
+			copies: binary.LittleEndian.Uint32(buffer[28:32]),
+			posidx: binary.LittleEndian.Uint32(buffer[32:36]),
+		}
+	}
 
-		if _, ok := files[name]; ok {
-			files[name].Count++
-			if files[name].Size != file.Size {
-				log.Printf("File is not copy %s\n", name)
-			}
-		} else {
-			files[name] = file
+	// position map follows records, bytes after last used position kept as trailer
+	rest, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	posmap := make([]uint32, len(rest)/4)
+	for i := range posmap {
+		posmap[i] = binary.LittleEndian.Uint32(rest[i*4:])
+	}
+
+	files := NewTokFile()
+	used := uint32(0)
+	for i, r := range records {
+		if r.copies == 0 {
+			return nil, fmt.Errorf("File '%s' has zero copies", r.name)
+		}
+		if r.posidx > uint32(len(posmap)) || r.copies > uint32(len(posmap))-r.posidx {
+			return nil, fmt.Errorf("File '%s' positions %d+%d out of position map of %d", r.name, r.posidx, r.copies, len(posmap))
+		}
+		if end := r.posidx + r.copies; end > used {
+			used = end
 		}
 
-		if file.StartSec > maxindex {
-			maxindex = file.StartSec
+		for _, pos := range posmap[r.posidx : r.posidx+r.copies] {
+			files.Add(&Entry{
+				Name:     r.name,
+				Pack:     pos / SectorsInFile,
+				Size:     r.size,
+				StartSec: pos % SectorsInFile,
+				Index:    i,
+			})
 		}
 	}
+	files.Trailer = rest[used*4:]
 
-	buffer = make([]byte, 4)
-	posmap := make([]uint32, maxindex+1)
-	for i := range posmap {
-		_, err := file.Read(buffer)
-		if err != nil {
-			return nil, err
+	return files, nil
+}
+
+// GOF 1
+func encodeTok1(w io.Writer, files []*Entry) error {
+	buffer := make([]byte, 24)
+
+	for _, f := range files {
+		if len(f.Name) > 12 {
+			return fmt.Errorf("File name '%s' too long for GoW1 toc", f.Name)
+		}
+		for i := range buffer {
+			buffer[i] = 0
+		}
+		copy(buffer[0:12], f.Name)
+		binary.LittleEndian.PutUint32(buffer[12:16], f.Pack)
+		binary.LittleEndian.PutUint32(buffer[16:20], f.Size)
+		binary.LittleEndian.PutUint32(buffer[20:24], f.StartSec)
+
+		if _, err := w.Write(buffer); err != nil {
+			return err
 		}
-		sz := binary.LittleEndian.Uint32(buffer)
-		posmap[i] = sz
 	}
 
+	// empty name ends table
+	for i := range buffer {
+		buffer[i] = 0
+	}
+	_, err := w.Write(buffer)
+	return err
+}
+
+// GOF 2
+func encodeTok2(w io.Writer, files []*Entry) error {
+	// every record stored with index of first position and count of copies in
+	// position map. Entries of tok file grouped by their record, so repeated
+	// records of same name are kept; new entries grouped by name
+	type record struct {
+		name  string
+		index int
+	}
+	records := make([]record, 0)
+	copies := make(map[record][]*Entry)
 	for _, f := range files {
-		pos := posmap[f.StartSec]
-		f.StartSec = pos % SectorsInFile
-		f.Pack = pos / SectorsInFile
+		r := record{name: f.Name, index: f.Index}
+		if f.Index < 0 {
+			r.index = -1
+		}
+		if _, ok := copies[r]; !ok {
+			records = append(records, r)
+		}
+		copies[r] = append(copies[r], f)
 	}
 
-	return files, nil
+	buffer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buffer, uint32(len(records)))
+	if _, err := w.Write(buffer); err != nil {
+		return err
+	}
+
+	posmap := make([]uint32, 0, len(files))
+
+	buffer = make([]byte, 36)
+	for _, r := range records {
+		name := r.name
+		if len(name) > 24 {
+			return fmt.Errorf("File name '%s' too long for GoW2 toc", name)
+		}
+		fcopies := copies[r]
+
+		for i := range buffer {
+			buffer[i] = 0
+		}
+		copy(buffer[0:24], name)
+		binary.LittleEndian.PutUint32(buffer[24:28], fcopies[0].Size)
+		binary.LittleEndian.PutUint32(buffer[28:32], uint32(len(fcopies)))
+		binary.LittleEndian.PutUint32(buffer[32:36], uint32(len(posmap)))
+
+		for _, f := range fcopies {
+			posmap = append(posmap, f.Pack*SectorsInFile+f.StartSec)
+		}
+
+		if _, err := w.Write(buffer); err != nil {
+			return err
+		}
+	}
+
+	buffer = make([]byte, 4)
+	for _, pos := range posmap {
+		binary.LittleEndian.PutUint32(buffer, pos)
+		if _, err := w.Write(buffer); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func Decode(file io.ReadSeeker, version int) (files TokFile, err error) {
+func Decode(file io.ReadSeeker, version int) (files *TokFile, err error) {
 	if version == utils.GAME_VERSION_UNKNOWN {
 		version, err = DetectVersion(file)
 		file.Seek(0, os.SEEK_SET)
@@ -151,11 +357,44 @@ func Decode(file io.ReadSeeker, version int) (files TokFile, err error) {
 
 	switch version {
 	case utils.GAME_VERSION_GOW_1:
-		return parseTok1(file)
+		files, err = parseTok1(file)
 	case utils.GAME_VERSION_GOW_2:
-		return parseTok2(file)
+		files, err = parseTok2(file)
 	case utils.GAME_VERSION_UNKNOWN:
 		return nil, errors.New("Unknown tok version for parsing")
 	}
+	if files != nil {
+		files.Version = version
+	}
 	return
 }
+
+// Writes tok file with entries in given order.
+// For GoW2 position map regenerated and entries of same record
+// (same name for new entries) stored as copies of one file
+func EncodeEntries(w io.Writer, entries []*Entry, version int) error {
+	switch version {
+	case utils.GAME_VERSION_GOW_1:
+		return encodeTok1(w, entries)
+	case utils.GAME_VERSION_GOW_2:
+		for _, e := range entries {
+			if e.StartSec >= SectorsInFile {
+				return fmt.Errorf("File '%s' start sector 0x%x out of pack bounds", e.Name, e.StartSec)
+			}
+		}
+		return encodeTok2(w, entries)
+	}
+	return errors.New("Unknown tok version for encoding")
+}
+
+// Writes tok file with entries and trailer of decoded tok.
+// Decoded tok written back byte-exact, except GoW1 toc without
+// terminating record (it gets one) and GoW2 position map which
+// order differs from order of records (it regenerated in record order)
+func Encode(w io.Writer, files *TokFile, version int) error {
+	if err := EncodeEntries(w, files.Entries, version); err != nil {
+		return err
+	}
+	_, err := w.Write(files.Trailer)
+	return err
+}
@@ -0,0 +1,103 @@
+package tok
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+func tok1Record(name string, pack, size, sec uint32) []byte {
+	buf := make([]byte, 24)
+	copy(buf, name)
+	binary.LittleEndian.PutUint32(buf[12:], pack)
+	binary.LittleEndian.PutUint32(buf[16:], size)
+	binary.LittleEndian.PutUint32(buf[20:], sec)
+	return buf
+}
+
+func tok2Record(name string, size, copies, posidx uint32) []byte {
+	buf := make([]byte, 36)
+	copy(buf, name)
+	binary.LittleEndian.PutUint32(buf[24:], size)
+	binary.LittleEndian.PutUint32(buf[28:], copies)
+	binary.LittleEndian.PutUint32(buf[32:], posidx)
+	return buf
+}
+
+func tok2(records [][]byte, posmap []uint32, trailer []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(len(records)))
+	for _, r := range records {
+		buf.Write(r)
+	}
+	binary.Write(&buf, binary.LittleEndian, posmap)
+	buf.Write(trailer)
+	return buf.Bytes()
+}
+
+func roundTrip(t *testing.T, data []byte, version int) *TokFile {
+	files, err := Decode(bytes.NewReader(data), version)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	var out bytes.Buffer
+	if err := Encode(&out, files, version); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("Round trip differs:\n got % x\nwant % x", out.Bytes(), data)
+	}
+	return files
+}
+
+func TestTok1RoundTrip(t *testing.T) {
+	var data []byte
+	data = append(data, tok1Record("R_BOAT.WAD", 0, 0x1234, 0)...)
+	data = append(data, tok1Record("SANITY.TXT", 0, 0x20, 3)...)
+	data = append(data, tok1Record("R_BOAT.WAD", 1, 0x1234, 0x10)...)
+	data = append(data, make([]byte, 24)...)
+	data = append(data, make([]byte, 0x38)...) // sector padding after terminator
+
+	files := roundTrip(t, data, utils.GAME_VERSION_GOW_1)
+	if len(files.Entries) != 3 || files.Files["R_BOAT.WAD"].Count != 1 {
+		t.Fatalf("Wrong entries: %d, copies of R_BOAT.WAD %d", len(files.Entries), files.Files["R_BOAT.WAD"].Count)
+	}
+	if len(files.Trailer) != 0x38 {
+		t.Fatalf("Wrong trailer size %d", len(files.Trailer))
+	}
+}
+
+func TestTok2RoundTrip(t *testing.T) {
+	data := tok2([][]byte{
+		tok2Record("R_BOAT.WAD", 0x1234, 2, 0),
+		tok2Record("SANITY.TXT", 0x20, 1, 2),
+		// repeated record of same name must stay separate record
+		tok2Record("SANITY.TXT", 0x20, 1, 3),
+	}, []uint32{0, SectorsInFile + 5, 3, 9}, []byte{0, 0, 0, 0, 0, 0})
+
+	files := roundTrip(t, data, utils.GAME_VERSION_GOW_2)
+	boat := files.Files["R_BOAT.WAD"]
+	if len(boat.Copies) != 2 || boat.Copies[1].Pack != 1 || boat.Copies[1].StartSec != 5 {
+		t.Fatalf("Wrong copies of R_BOAT.WAD: %+v", boat.Copies)
+	}
+	if len(files.Files["SANITY.TXT"].Copies) != 2 {
+		t.Fatalf("Wrong copies of SANITY.TXT")
+	}
+	if len(files.Trailer) != 6 {
+		t.Fatalf("Wrong trailer size %d", len(files.Trailer))
+	}
+}
+
+func TestTok2WrongCopies(t *testing.T) {
+	for _, data := range [][]byte{
+		tok2([][]byte{tok2Record("A.WAD", 1, 0, 0)}, []uint32{0}, nil),
+		tok2([][]byte{tok2Record("A.WAD", 1, 3, 0)}, []uint32{0, 1}, nil),
+		tok2([][]byte{tok2Record("A.WAD", 1, 1, 2)}, []uint32{0, 1}, nil),
+	} {
+		if _, err := Decode(bytes.NewReader(data), utils.GAME_VERSION_GOW_2); err == nil {
+			t.Errorf("No error for wrong copies count: % x", data)
+		}
+	}
+}
@@ -2,13 +2,14 @@ package txr
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	"image/png"
 	"io"
 	"log"
-	"math"
 	"os"
 	"path"
 
@@ -27,8 +28,12 @@ type Texture struct {
 const FILE_SIZE = 0x58
 const FILE_MAGIC = 0x7
 
+// Exports texture to png files
+type PngExporter struct{}
+
 func init() {
-	wad.PregisterExporter(FILE_MAGIC, &Texture{})
+	wad.RegisterDecoder(utils.GAME_VERSION_GOW_1, FILE_MAGIC, &Texture{})
+	wad.RegisterExporter(utils.GAME_VERSION_GOW_1, FILE_MAGIC, &PngExporter{})
 }
 
 func NewFromData(fin io.ReaderAt) (*Texture, error) {
@@ -51,125 +56,413 @@ func NewFromData(fin io.ReaderAt) (*Texture, error) {
 	return tex, nil
 }
 
+// Returns image of gfx data block. Pallet can be nil for direct color gfx
 func (txr *Texture) Image(gfx *file_gfx.GFX, pal *file_gfx.GFX, igfx int, ipal int) (image.Image, error) {
-	width := int(gfx.Width)
-	height := int(gfx.Height)
+	if gfx.IsDirectColor() {
+		return gfx.GetImage(igfx)
+	}
+	if pal == nil {
+		return nil, errors.New("Pallet required for indexed gfx")
+	}
+
+	width, height := gfx.BlockSize(igfx)
 
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
 	pallete, err := pal.GetPallet(ipal)
 
 	if err != nil {
 		return nil, err
 	}
 
-	data := gfx.Data[igfx]
+	data, err := gfx.LinearData(igfx)
+	if err != nil {
+		return nil, err
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, pallete[data[x+y*width]])
+		}
+	}
 
-	encoding := gfx.Encoding
+	return img, nil
+}
 
-	if gfx.Bpi == 4 {
-		encoding = 2
+// Inverse of Image. Image quantized to pallet size and stored into gfx and pal
+// data blocks. If keepPal, pallet not changed and nearest colors of it used
+func (txr *Texture) SetImage(gfx *file_gfx.GFX, pal *file_gfx.GFX, igfx int, ipal int, img image.Image, keepPal bool) error {
+	if gfx.IsDirectColor() {
+		return gfx.SetImage(igfx, img)
+	}
+	if pal == nil {
+		return errors.New("Pallet required for indexed gfx")
 	}
 
-	switch encoding {
-	case 0:
-		for y := 0; y < height; y++ {
-			for x := 0; x < width; x++ {
-				// apply swizzle
-				block_location := (y&(math.MaxInt32^0xf))*width + (x&(math.MaxInt32^0xf))*2
-				swap_selector := (((y + 2) >> 2) & 0x1) * 4
-				posY := (((y & (math.MaxInt32 ^ 3)) >> 1) + (y & 1)) & 0x7
-				column_location := posY*width*2 + ((x+swap_selector)&0x7)*4
+	width, height := gfx.BlockSize(igfx)
 
-				byte_num := ((y >> 1) & 1) + ((x >> 2) & 2) // 0,1,2,3
+	bounds := img.Bounds()
+	if bounds.Dx() != width || bounds.Dy() != height {
+		return fmt.Errorf("Wrong image size %dx%d, required %dx%d", bounds.Dx(), bounds.Dy(), width, height)
+	}
 
-				datapos := block_location + column_location + byte_num
-				palpos := data[datapos]
+	// 4 bit indexes can use only first 16 colors of pallet
+	colors := int(pal.Width * pal.Height)
+	count := colors
+	if gfx.Bpi == 4 && count > 16 {
+		count = 16
+	}
 
-				img.Set(x, y, pallete[palpos])
-			}
+	var pallete color.Palette
+	if keepPal {
+		var err error
+		if pallete, err = pal.GetPallet(ipal); err != nil {
+			return err
 		}
-	case 2:
-		for y := 0; y < height; y++ {
-			for x := 0; x < width; x++ {
-				img.Set(x, y, pallete[data[x+y*width]])
-			}
+	} else {
+		pallete = utils.QuantizeImage(img, count)
+		for len(pallete) < colors {
+			pallete = append(pallete, color.NRGBA{})
 		}
 	}
+	indexes := pallete[:count]
 
-	return img, nil
+	data := make([]byte, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			data[x+y*width] = byte(utils.NearestColor(indexes, img.At(bounds.Min.X+x, bounds.Min.Y+y)))
+		}
+	}
+
+	if err := gfx.SetLinearData(igfx, data); err != nil {
+		return err
+	}
+	if !keepPal {
+		return pal.SetPallet(ipal, pallete)
+	}
+	return nil
+}
+
+// Exported png image of texture
+type ExportedImage struct {
+	File   string `json:"file,omitempty"` // empty if image not exported
+	Error  string `json:"error,omitempty"`
+	Kind   string `json:"kind"` // "frame" or "mip"
+	Gfx    int    `json:"gfx"`  // data block of gfx: frame index or mip level
+	Pal    int    `json:"pal"`  // data block of pallet
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Texture linked by SubTxrName chain. Error set if texture not exported
+// or link of chain is broken (texture not found or cycle)
+type SubManifest struct {
+	Texture string           `json:"texture"`
+	Error   string           `json:"error,omitempty"`
+	Link    string           `json:"link,omitempty"` // "mip" if half size of previous texture in chain, otherwise "variant"
+	Gfx     string           `json:"gfx,omitempty"`
+	Pal     string           `json:"pal,omitempty"`
+	Images  []*ExportedImage `json:"images,omitempty"`
+}
+
+// Description of exported images, written when texture has more than one image
+type Manifest struct {
+	Gfx    string           `json:"gfx"`
+	Pal    string           `json:"pal,omitempty"`
+	Images []*ExportedImage `json:"images"`
+	Sub    []*SubManifest   `json:"sub,omitempty"`
 }
 
+// Exports every frame (or mip level) of gfx with every pallet. First image is out.png,
+// other frames are out.<gfx>.<pal>.png and mip levels are out.mip<level>.<pal>.png
 func (txr *Texture) Extract(gfx *file_gfx.GFX, pal *file_gfx.GFX, out string) ([]string, error) {
+	names, images, err := txr.extractImages(gfx, pal, out)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(images) > 1 {
+		manifestFileName := out + ".json"
+		if err := writeManifest(manifestFileName, &Manifest{Gfx: txr.GfxName, Pal: txr.PalName, Images: images}); err != nil {
+			return nil, err
+		}
+		names = append(names, manifestFileName)
+	}
+
+	return names, nil
+}
+
+func (txr *Texture) extractImages(gfx *file_gfx.GFX, pal *file_gfx.GFX, out string) ([]string, []*ExportedImage, error) {
 	names := make([]string, 0)
+	images := make([]*ExportedImage, 0)
+
+	pals := 1
+	if pal != nil && !gfx.IsDirectColor() {
+		pals = len(pal.Data)
+	}
 	for iGfx := range gfx.Data {
-		for iPal := range pal.Data {
+		for iPal := 0; iPal < pals; iPal++ {
+			kind := "frame"
+			if gfx.Mipmaps {
+				kind = "mip"
+			}
+			width, height := gfx.BlockSize(iGfx)
+
 			img, err := txr.Image(gfx, pal, iGfx, iPal)
 			if err != nil {
-				return nil, err
+				if !gfx.Mipmaps || iGfx == 0 {
+					return nil, nil, err
+				}
+				// broken small mip level must not lose whole texture
+				log.Printf("Mip level %d of '%s' skipped: %v", iGfx, out, err)
+				images = append(images, &ExportedImage{
+					Error:  err.Error(),
+					Kind:   kind,
+					Gfx:    iGfx,
+					Pal:    iPal,
+					Width:  width,
+					Height: height,
+				})
+				continue
 			}
 
 			var resultFileName string
 			if iGfx == 0 && iPal == 0 {
 				resultFileName = out + ".png"
+			} else if gfx.Mipmaps {
+				resultFileName = fmt.Sprintf("%s.mip%d.%d.png", out, iGfx, iPal)
 			} else {
 				resultFileName = fmt.Sprintf("%s.%d.%d.png", out, iGfx, iPal)
 			}
 
-			err = os.MkdirAll(path.Dir(resultFileName), 0777)
-			if err != nil {
-				return nil, err
+			if err := writePng(resultFileName, img); err != nil {
+				return nil, nil, err
 			}
 
-			fof, err := os.Create(resultFileName)
-			if err != nil {
-				return nil, err
-			}
-			defer fof.Close()
+			names = append(names, resultFileName)
+			images = append(images, &ExportedImage{
+				File:   path.Base(resultFileName),
+				Kind:   kind,
+				Gfx:    iGfx,
+				Pal:    iPal,
+				Width:  width,
+				Height: height,
+			})
+		}
+	}
 
-			if err = png.Encode(fof, img); err != nil {
-				return nil, err
-			}
+	return names, images, nil
+}
 
-			names = append(names, resultFileName)
+// Exports texture with textures of SubTxrName chain. Sub textures
+// stored as out.sub<n>.png (same naming as Extract) and described in out.json.
+// Sub textures are exported as their own nodes too, copies in chain keep
+// manifest complete. Broken sub textures do not fail export of texture
+func (txr *Texture) ExtractChain(nd *wad.WadNode, out string) ([]string, error) {
+	gfx, pal, err := txr.FindGfxPal(nd)
+	if err != nil {
+		return nil, err
+	}
+
+	subs, subsErr := SubTextures(nd)
+	if len(subs) == 0 && subsErr == nil {
+		return txr.Extract(gfx, pal, out)
+	}
+
+	names, images, err := txr.extractImages(gfx, pal, out)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{Gfx: txr.GfxName, Pal: txr.PalName, Images: images, Sub: make([]*SubManifest, 0, len(subs)+1)}
+
+	prev, last := gfx, txr
+	for i, snd := range subs {
+		stxr := snd.Cache().(*Texture)
+		last = stxr
+		sub := &SubManifest{Texture: snd.Name, Gfx: stxr.GfxName, Pal: stxr.PalName}
+		manifest.Sub = append(manifest.Sub, sub)
+
+		sgfx, spal, err := stxr.FindGfxPal(snd)
+		if err == nil {
+			var snames []string
+			snames, sub.Images, err = stxr.extractImages(sgfx, spal, fmt.Sprintf("%s.sub%d", out, i+1))
+			names = append(names, snames...)
+		}
+		if err != nil {
+			log.Printf("Sub texture '%s' of '%s' skipped: %v", snd.Path, nd.Path, err)
+			sub.Error = err.Error()
+			continue
+		}
+
+		sub.Link = "variant"
+		if sgfx.Width*2 == prev.Width && sgfx.Height*2 == prev.Height {
+			sub.Link = "mip"
 		}
+		prev = sgfx
 	}
 
-	return names, nil
+	if subsErr != nil {
+		log.Printf("Sub textures chain of '%s' broken: %v", nd.Path, subsErr)
+		manifest.Sub = append(manifest.Sub, &SubManifest{Texture: last.SubTxrName, Error: subsErr.Error()})
+	}
+
+	manifestFileName := out + ".json"
+	if err := writeManifest(manifestFileName, manifest); err != nil {
+		return nil, err
+	}
+	return append(names, manifestFileName), nil
 }
 
-func (*Texture) ExtractFromNode(nd *wad.WadNode, outfname string) error {
-	reader, err := nd.DataReader()
+func writePng(fname string, img image.Image) error {
+	if err := os.MkdirAll(path.Dir(fname), 0777); err != nil {
+		return err
+	}
+
+	fof, err := os.Create(fname)
 	if err != nil {
 		return err
 	}
+	defer fof.Close()
+
+	if err := png.Encode(fof, img); err != nil {
+		return err
+	}
+	return fof.Close()
+}
 
-	txr, err := NewFromData(reader)
+func writeManifest(fname string, manifest *Manifest) error {
+	f, err := os.Create(fname)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (*Texture) Decode(nd *wad.WadNode) (interface{}, error) {
+	reader, err := nd.DataReader()
+	if err != nil {
+		return nil, err
+	}
+	return NewFromData(reader)
+}
 
-	if txr.GfxName != "" && txr.PalName != "" {
-		gfxnd := nd.Find(txr.GfxName, true)
-		palnd := nd.Find(txr.PalName, true)
+// Returns node (link resolved) and decoded GFX found by name from texture node
+func FindGfx(nd *wad.WadNode, name string) (*wad.WadNode, *file_gfx.GFX, error) {
+	gfxnd := nd.Find(name, true)
+	if gfxnd != nil {
+		gfxnd = gfxnd.Resolve()
+	}
+	if gfxnd == nil {
+		return nil, nil, fmt.Errorf("GFX '%s' not found", name)
+	}
 
-		if gfxnd == nil || !gfxnd.Extracted || gfxnd.Cache == nil {
-			return fmt.Errorf("GFX '%s' not cached", txr.GfxName)
+	decoded, err := gfxnd.Decode()
+	if err != nil {
+		return nil, nil, fmt.Errorf("GFX '%s' decoding error: %v", name, err)
+	}
+	gfx, ok := decoded.(*file_gfx.GFX)
+	if !ok {
+		return nil, nil, fmt.Errorf("Node '%s' is not GFX", gfxnd.Path)
+	}
+	return gfxnd, gfx, nil
+}
+
+// Returns gfx and pallet of texture. Pallet is nil for direct color gfx without pallet
+func (txr *Texture) FindGfxPal(nd *wad.WadNode) (*file_gfx.GFX, *file_gfx.GFX, error) {
+	if txr.GfxName == "" {
+		return nil, nil, fmt.Errorf("Texture '%s' without gfx", nd.Path)
+	}
+
+	_, gfx, err := FindGfx(nd, txr.GfxName)
+	if err != nil {
+		return nil, nil, err
+	}
+	var pal *file_gfx.GFX
+	if txr.PalName != "" {
+		if _, pal, err = FindGfx(nd, txr.PalName); err != nil {
+			return nil, nil, err
+		}
+	} else if !gfx.IsDirectColor() {
+		return nil, nil, fmt.Errorf("Texture '%s' with indexed gfx has no pallet", nd.Path)
+	}
+	return gfx, pal, nil
+}
+
+// Returns decoded texture nodes (links resolved) of SubTxrName chain, without nd itself.
+// On broken link returns textures before it with error
+func SubTextures(nd *wad.WadNode) ([]*wad.WadNode, error) {
+	subs := make([]*wad.WadNode, 0)
+	visited := map[*wad.WadNode]bool{nd: true}
+
+	decoded, err := nd.Decode()
+	if err != nil {
+		return subs, err
+	}
+	txr, ok := decoded.(*Texture)
+	if !ok {
+		return subs, fmt.Errorf("Node '%s' is not texture", nd.Path)
+	}
+
+	cur := nd
+	for txr.SubTxrName != "" {
+		next := cur.Find(txr.SubTxrName, true)
+		if next != nil {
+			next = next.Resolve()
 		}
-		if palnd == nil || !palnd.Extracted || palnd.Cache == nil {
-			return fmt.Errorf("GFX '%s' not cached", txr.PalName)
+		if next == nil {
+			return subs, fmt.Errorf("Sub texture '%s' of '%s' not found", txr.SubTxrName, cur.Path)
 		}
+		if visited[next] {
+			return subs, fmt.Errorf("Cycle in sub textures of '%s' at '%s'", nd.Path, next.Path)
+		}
+		visited[next] = true
+
+		decoded, err := next.Decode()
+		if err != nil {
+			return subs, fmt.Errorf("Sub texture '%s' decoding error: %v", next.Path, err)
+		}
+		if txr, ok = decoded.(*Texture); !ok {
+			return subs, fmt.Errorf("Node '%s' is not texture", next.Path)
+		}
+		subs = append(subs, next)
+		cur = next
+	}
+	return subs, nil
+}
+
+func (*PngExporter) Dependencies(nd *wad.WadNode) ([]wad.Dependency, error) {
+	decoded, err := nd.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	txr := decoded.(*Texture)
+	if txr.GfxName == "" {
+		return nil, nil
+	}
+	if txr.PalName == "" {
+		return []wad.Dependency{{Name: txr.GfxName}}, nil
+	}
+	return []wad.Dependency{{Name: txr.GfxName}, {Name: txr.PalName}}, nil
+}
 
-		resultfiles, err := txr.Extract(
-			gfxnd.Cache.(*file_gfx.GFX),
-			palnd.Cache.(*file_gfx.GFX), outfname)
+func (*PngExporter) Export(nd *wad.WadNode, decoded interface{}, outfname string) error {
+	txr := decoded.(*Texture)
 
+	if txr.GfxName != "" {
+		resultfiles, err := txr.ExtractChain(nd, outfname)
 		if err != nil {
 			return err
 		}
 		log.Printf("Texture '%s' extracted: %s", nd.Path, resultfiles)
 
-		nd.ExtractedNames = resultfiles
-		nd.Cache = txr
+		nd.AddExtractedNames(resultfiles...)
 	}
 	return nil
 }
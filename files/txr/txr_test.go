@@ -0,0 +1,155 @@
+package txr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	file_gfx "github.com/mogaika/god_of_war_tools/files/gfx"
+)
+
+func gfxData(width, height, encoding, bpi uint32, blocks [][]byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint32{file_gfx.GFX_MAGIC, width, height, encoding, bpi, uint32(len(blocks))})
+	for _, b := range blocks {
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+func decodeGfx(t *testing.T, data []byte) *file_gfx.GFX {
+	gfx, err := file_gfx.NewFromData(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gfx
+}
+
+// Mip levels smaller than gs block can not be swizzled, they must be exported linear
+func TestExtractSmallMips(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	blocks := make([][]byte, 0)
+	for size := 64; size >= 8; size /= 2 {
+		b := make([]byte, size*size)
+		rnd.Read(b)
+		blocks = append(blocks, b)
+	}
+	data := gfxData(64, 64, 0, 8, blocks)
+
+	palBlock := make([]byte, 256*4)
+	for i := 0; i < 256; i++ {
+		palBlock[i*4], palBlock[i*4+1], palBlock[i*4+2], palBlock[i*4+3] = byte(i), byte(255-i), byte(i*3), 0x80
+	}
+	pal := decodeGfx(t, gfxData(16, 16, 0, 32, [][]byte{palBlock}))
+
+	gfx := decodeGfx(t, data)
+	if !gfx.Mipmaps || len(gfx.Data) != 4 {
+		t.Fatalf("Wrong layout: mipmaps %v, blocks %d", gfx.Mipmaps, len(gfx.Data))
+	}
+	if marshaled, err := gfx.Marshal(); err != nil || !bytes.Equal(marshaled, data) {
+		t.Fatalf("Marshal differs from source data: %v", err)
+	}
+
+	out := filepath.Join(t.TempDir(), "T")
+	txr := &Texture{GfxName: "G", PalName: "P"}
+	names, err := txr.Extract(gfx, pal, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 5 {
+		t.Fatalf("Wrong exported files %v", names)
+	}
+
+	mf, err := os.Open(out + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mf.Close()
+	var manifest Manifest
+	if err := json.NewDecoder(mf).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	for i, img := range manifest.Images {
+		if img.File == "" || img.Kind != "mip" || img.Gfx != i || img.Width != 64>>uint(i) {
+			t.Errorf("Wrong manifest image %d: %+v", i, img)
+		}
+	}
+
+	// smallest level stored linear
+	f, err := os.Open(filepath.Join(filepath.Dir(out), manifest.Images[3].File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pallet, err := pal.GetPallet(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			want := pallet[blocks[3][x+y*8]]
+			if got := color.NRGBAModel.Convert(img.At(x, y)); got != want {
+				t.Fatalf("Pixel %d,%d of 8x8 level is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+// Png with colors count fitting into pallet is imported and exported back exactly
+func TestSetImageRoundTrip(t *testing.T) {
+	for _, bpi := range []uint32{4, 8} {
+		for _, size := range []int{128, 64} {
+			colors, palWidth, palHeight := 256, uint32(16), uint32(16)
+			if bpi == 4 {
+				colors, palWidth, palHeight = 16, 8, 2
+			}
+
+			src := image.NewNRGBA(image.Rect(0, 0, size, size))
+			rnd := rand.New(rand.NewSource(int64(size)))
+			for i := 0; i < size*size; i++ {
+				c := rnd.Intn(colors)
+				// alpha values which survive 0-128 range of ps2
+				alpha := []uint8{0, 255, 127, 1}[c%4]
+				src.SetNRGBA(i%size, i/size, color.NRGBA{uint8(c), uint8(c * 7), uint8(255 - c), alpha})
+			}
+			var pngData bytes.Buffer
+			if err := png.Encode(&pngData, src); err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(&pngData)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gfx := decodeGfx(t, gfxData(uint32(size), uint32(size), 0, bpi, [][]byte{make([]byte, size*size*int(bpi)/8)}))
+			pal := decodeGfx(t, gfxData(palWidth, palHeight, 0, 32, [][]byte{make([]byte, palWidth*palHeight*4)}))
+
+			txr := &Texture{GfxName: "G", PalName: "P"}
+			if err := txr.SetImage(gfx, pal, 0, 0, img, false); err != nil {
+				t.Fatalf("bpi %d %dx%d: %v", bpi, size, size, err)
+			}
+			res, err := txr.Image(gfx, pal, 0, 0)
+			if err != nil {
+				t.Fatalf("bpi %d %dx%d: %v", bpi, size, size, err)
+			}
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					if got, want := color.NRGBAModel.Convert(res.At(x, y)), src.NRGBAAt(x, y); got != want {
+						t.Fatalf("bpi %d %dx%d: pixel %d,%d is %v, want %v", bpi, size, size, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
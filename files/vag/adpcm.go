@@ -0,0 +1,140 @@
+package vag
+
+// Sony PS-ADPCM, used in VAG, VPK and PSS audio
+const (
+	FRAME_SIZE    = 0x10
+	FRAME_SAMPLES = 28
+)
+
+// Frame flags
+const (
+	FLAG_LOOP_END    = 0x1 // last frame of loop or stream
+	FLAG_LOOP_REPEAT = 0x2 // with FLAG_LOOP_END: jump to loop start, otherwise stop
+	FLAG_LOOP_START  = 0x4
+	FLAG_STREAM_END  = 0x7 // empty frame after stream
+)
+
+var coefs = [5][2]int{
+	{0, 0},
+	{60, 0},
+	{115, -52},
+	{98, -55},
+	{122, -60},
+}
+
+// Decodes one channel, keeps history between frames
+type Decoder struct {
+	hist1, hist2 int
+}
+
+func clamp16(v int) int16 {
+	if v > 32767 {
+		return 32767
+	} else if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
+
+// Decodes FRAME_SIZE bytes of frame into FRAME_SAMPLES samples of out.
+// Returns frame flags
+func (d *Decoder) DecodeFrame(frame []byte, out []int16) byte {
+	predictor := int(frame[0] >> 4)
+	shift := uint(frame[0] & 0xf)
+	flags := frame[1]
+
+	if predictor >= len(coefs) {
+		predictor = 0
+	}
+	if shift > 12 {
+		shift = 9
+	}
+	c := coefs[predictor]
+
+	for i := 0; i < FRAME_SAMPLES; i++ {
+		nibble := int(frame[2+i/2])
+		if i&1 == 0 {
+			nibble &= 0xf
+		} else {
+			nibble >>= 4
+		}
+
+		// sign extend 4 bit value to 16 bit scale
+		sample := int(int16(nibble<<12)) >> shift
+		sample += (d.hist1*c[0] + d.hist2*c[1] + 32) >> 6
+
+		out[i] = clamp16(sample)
+		d.hist2 = d.hist1
+		d.hist1 = int(out[i])
+	}
+	return flags
+}
+
+// Decodes mono stream of frames.
+// Returns samples and loop position in samples (-1 if not looped)
+func DecodeStream(data []byte) (samples []int16, loopStart int, loopEnd int) {
+	var d Decoder
+	samples = make([]int16, 0, len(data)/FRAME_SIZE*FRAME_SAMPLES)
+	loopStart, loopEnd = -1, -1
+
+	frame := make([]int16, FRAME_SAMPLES)
+	for pos := 0; pos+FRAME_SIZE <= len(data); pos += FRAME_SIZE {
+		if data[pos+1] == FLAG_STREAM_END {
+			break
+		}
+
+		flags := d.DecodeFrame(data[pos:pos+FRAME_SIZE], frame)
+		if flags&FLAG_LOOP_START != 0 {
+			loopStart = len(samples)
+		}
+		samples = append(samples, frame...)
+
+		if flags&FLAG_LOOP_END != 0 {
+			if flags&FLAG_LOOP_REPEAT != 0 {
+				loopEnd = len(samples)
+			}
+			break
+		}
+	}
+
+	if loopEnd < 0 {
+		loopStart = -1
+	}
+	return samples, loopStart, loopEnd
+}
+
+// Decodes stream where channels stored by interleaved blocks.
+// Returns samples interleaved by channels. Frame loop flags ignored
+func DecodeInterleaved(data []byte, channels int, interleave int) []int16 {
+	chData := make([][]byte, channels)
+	for pos, iBlock := 0, 0; pos < len(data); pos, iBlock = pos+interleave, iBlock+1 {
+		end := pos + interleave
+		if end > len(data) {
+			end = len(data)
+		}
+		ch := iBlock % channels
+		chData[ch] = append(chData[ch], data[pos:end]...)
+	}
+
+	frames := len(chData[0]) / FRAME_SIZE
+	for _, ch := range chData {
+		if len(ch)/FRAME_SIZE < frames {
+			frames = len(ch) / FRAME_SIZE
+		}
+	}
+
+	samples := make([]int16, frames*FRAME_SAMPLES*channels)
+	frame := make([]int16, FRAME_SAMPLES)
+	for iCh, ch := range chData {
+		var d Decoder
+		for iFrame := 0; iFrame < frames; iFrame++ {
+			pos := iFrame * FRAME_SIZE
+			d.DecodeFrame(ch[pos:pos+FRAME_SIZE], frame)
+
+			for i, sample := range frame {
+				samples[(iFrame*FRAME_SAMPLES+i)*channels+iCh] = sample
+			}
+		}
+	}
+	return samples
+}
@@ -0,0 +1,101 @@
+package vag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeFrame(t *testing.T) {
+	// predictor 2 (115, -52), shift 4, nibbles 1, 7, -1, -8, then zeros
+	frame := make([]byte, FRAME_SIZE)
+	frame[0], frame[1] = 0x24, FLAG_LOOP_START
+	frame[2], frame[3] = 0x71, 0x8f
+
+	d := Decoder{hist1: 1000, hist2: -500}
+	out := make([]int16, FRAME_SAMPLES)
+	if flags := d.DecodeFrame(frame, out); flags != FLAG_LOOP_START {
+		t.Fatalf("Wrong flags 0x%x", flags)
+	}
+
+	want := []int16{2459, 5398, 7446, 6946, 6431}
+	for i, w := range want {
+		if out[i] != w {
+			t.Fatalf("Sample %d is %d, want %d (samples %v)", i, out[i], w, out[:len(want)])
+		}
+	}
+	if d.hist1 != int(out[FRAME_SAMPLES-1]) || d.hist2 != int(out[FRAME_SAMPLES-2]) {
+		t.Fatal("History not kept after frame")
+	}
+}
+
+func TestDecodeFrameClamp(t *testing.T) {
+	// predictor 1 (60, 0), shift 0, nibble 7
+	frame := make([]byte, FRAME_SIZE)
+	frame[0], frame[2] = 0x10, 0x07
+
+	d := Decoder{hist1: 32767}
+	out := make([]int16, FRAME_SAMPLES)
+	d.DecodeFrame(frame, out)
+	if out[0] != 32767 {
+		t.Fatalf("Sample not clamped: %d", out[0])
+	}
+}
+
+func vagFixture(flags ...byte) []byte {
+	file := make([]byte, HEADER_SIZE)
+	copy(file, VAG_MAGIC)
+	binary.BigEndian.PutUint32(file[0xc:], uint32(len(flags)*FRAME_SIZE))
+	binary.BigEndian.PutUint32(file[0x10:], 22050)
+	for _, f := range flags {
+		frame := bytes.Repeat([]byte{0x21}, FRAME_SIZE)
+		frame[0], frame[1] = 0x08, f
+		file = append(file, frame...)
+	}
+	return file
+}
+
+func TestDecodeStreamLoop(t *testing.T) {
+	v, err := NewFromData(bytes.NewReader(vagFixture(0, FLAG_LOOP_START, 0, FLAG_LOOP_END|FLAG_LOOP_REPEAT, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := v.Wav()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Samples) != 4*FRAME_SAMPLES {
+		t.Fatalf("Frames after loop end decoded: %d samples", len(w.Samples))
+	}
+	if w.Loop == nil || w.Loop.Start != FRAME_SAMPLES || w.Loop.End != 4*FRAME_SAMPLES {
+		t.Fatalf("Wrong loop %+v", w.Loop)
+	}
+
+	var buf bytes.Buffer
+	if err := w.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	pos := bytes.Index(data, []byte("smpl"))
+	if pos < 0 {
+		t.Fatal("No smpl chunk")
+	}
+	// chunk header, sampler fields and loop cue point id and type before loop points
+	loop := data[pos+8+36+8:]
+	if start, end := binary.LittleEndian.Uint32(loop), binary.LittleEndian.Uint32(loop[4:]); start != FRAME_SAMPLES || end != 4*FRAME_SAMPLES-1 {
+		t.Fatalf("Wrong smpl loop %d - %d", start, end)
+	}
+}
+
+func TestDecodeStreamEnd(t *testing.T) {
+	// loop end without repeat stops stream without loop
+	samples, loopStart, loopEnd := DecodeStream(vagFixture(FLAG_LOOP_START, FLAG_LOOP_END, 0)[HEADER_SIZE:])
+	if len(samples) != 2*FRAME_SAMPLES || loopStart != -1 || loopEnd != -1 {
+		t.Fatalf("Wrong stream: %d samples, loop %d - %d", len(samples), loopStart, loopEnd)
+	}
+
+	samples, _, _ = DecodeStream(vagFixture(0, FLAG_STREAM_END, 0)[HEADER_SIZE:])
+	if len(samples) != FRAME_SAMPLES {
+		t.Fatalf("Frames after stream end decoded: %d samples", len(samples))
+	}
+}
@@ -0,0 +1,64 @@
+package vag
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/mogaika/god_of_war_tools/utils"
+	"github.com/mogaika/god_of_war_tools/utils/wav"
+)
+
+const HEADER_SIZE = 0x30
+const VAG_MAGIC = "VAGp"
+
+// Mono sound with big-endian header
+type Vag struct {
+	Version    uint32
+	DataSize   uint32
+	SampleRate uint32
+	Name       string
+	Data       []byte // ADPCM frames
+}
+
+func NewFromData(r io.Reader) (*Vag, error) {
+	file, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(file) < HEADER_SIZE || string(file[0:4]) != VAG_MAGIC {
+		return nil, errors.New("Wrong magic.")
+	}
+
+	vag := &Vag{
+		Version:    binary.BigEndian.Uint32(file[0x4:0x8]),
+		DataSize:   binary.BigEndian.Uint32(file[0xc:0x10]),
+		SampleRate: binary.BigEndian.Uint32(file[0x10:0x14]),
+		Name:       utils.BytesToString(file[0x20:0x30]),
+	}
+
+	vag.Data = file[HEADER_SIZE:]
+	if int(vag.DataSize) < len(vag.Data) {
+		vag.Data = vag.Data[:vag.DataSize]
+	}
+	return vag, nil
+}
+
+func (vag *Vag) Wav() (*wav.Wav, error) {
+	if vag.SampleRate == 0 {
+		return nil, errors.New("Zero sample rate")
+	}
+
+	samples, loopStart, loopEnd := DecodeStream(vag.Data)
+
+	w := &wav.Wav{
+		Channels:   1,
+		SampleRate: int(vag.SampleRate),
+		Samples:    samples,
+	}
+	if loopStart >= 0 {
+		w.Loop = &wav.Loop{Start: uint32(loopStart), End: uint32(loopEnd)}
+	}
+	return w, nil
+}
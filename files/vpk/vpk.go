@@ -0,0 +1,80 @@
+package vpk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/mogaika/god_of_war_tools/files/vag"
+	"github.com/mogaika/god_of_war_tools/utils/wav"
+)
+
+// Same check as vgmstream: read_32bitBE(0) == 0x204B5056
+const VPK_MAGIC = " KPV"
+const HEADER_SIZE = 0x800
+
+// RAW ADPCM music stream. Channels stored by interleaved blocks
+type Vpk struct {
+	DataSize   uint32 // size of one channel data
+	DataStart  uint32
+	Interleave uint32 // size of one channel block
+	SampleRate uint32
+	Channels   uint32
+	LoopStart  uint32 // offset inside channel data, 0 if not looped
+	Data       []byte
+}
+
+func NewFromData(r io.Reader) (*Vpk, error) {
+	file, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(file) < HEADER_SIZE || string(file[0:4]) != VPK_MAGIC {
+		return nil, errors.New("Wrong magic.")
+	}
+
+	vpk := &Vpk{
+		DataSize:   binary.LittleEndian.Uint32(file[0x4:0x8]),
+		DataStart:  binary.LittleEndian.Uint32(file[0x8:0xc]),
+		SampleRate: binary.LittleEndian.Uint32(file[0x10:0x14]),
+		Channels:   binary.LittleEndian.Uint32(file[0x14:0x18]),
+		LoopStart:  binary.LittleEndian.Uint32(file[0x7fc:0x800]),
+	}
+
+	if vpk.Channels == 0 || vpk.SampleRate == 0 {
+		return nil, fmt.Errorf("Wrong channels count %d or sample rate %d", vpk.Channels, vpk.SampleRate)
+	}
+	// header stores interleave of all channels
+	vpk.Interleave = binary.LittleEndian.Uint32(file[0xc:0x10]) / vpk.Channels
+	if vpk.Interleave == 0 || vpk.Interleave%vag.FRAME_SIZE != 0 {
+		return nil, fmt.Errorf("Wrong interleave 0x%x", vpk.Interleave)
+	}
+
+	if int(vpk.DataStart) > len(file) {
+		return nil, errors.New("Data start out of file")
+	}
+	vpk.Data = file[vpk.DataStart:]
+	if size := uint64(vpk.DataSize) * uint64(vpk.Channels); size < uint64(len(vpk.Data)) {
+		vpk.Data = vpk.Data[:size]
+	}
+	return vpk, nil
+}
+
+func (vpk *Vpk) Wav() (*wav.Wav, error) {
+	w := &wav.Wav{
+		Channels:   int(vpk.Channels),
+		SampleRate: int(vpk.SampleRate),
+		// music streams ignore frame loop flags
+		Samples: vag.DecodeInterleaved(vpk.Data, int(vpk.Channels), int(vpk.Interleave)),
+	}
+
+	if vpk.LoopStart != 0 {
+		w.Loop = &wav.Loop{
+			Start: vpk.LoopStart / vag.FRAME_SIZE * vag.FRAME_SAMPLES,
+			End:   uint32(len(w.Samples) / w.Channels),
+		}
+	}
+	return w, nil
+}
@@ -0,0 +1,64 @@
+package vpk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/mogaika/god_of_war_tools/files/vag"
+)
+
+// Two channels of 2 frames each, interleaved by one frame.
+// First channel is silence, second is not. Data size is per channel,
+// padding after data must be cut
+func vpkFixture(magic string) []byte {
+	file := make([]byte, HEADER_SIZE)
+	copy(file, magic)
+	binary.LittleEndian.PutUint32(file[0x4:], 2*vag.FRAME_SIZE)
+	binary.LittleEndian.PutUint32(file[0x8:], HEADER_SIZE)
+	binary.LittleEndian.PutUint32(file[0xc:], 2*vag.FRAME_SIZE)
+	binary.LittleEndian.PutUint32(file[0x10:], 22050)
+	binary.LittleEndian.PutUint32(file[0x14:], 2)
+	binary.LittleEndian.PutUint32(file[0x7fc:], vag.FRAME_SIZE)
+
+	silence := make([]byte, vag.FRAME_SIZE)
+	sound := bytes.Repeat([]byte{0x11}, vag.FRAME_SIZE)
+	sound[0], sound[1] = 0, 0
+	for i := 0; i < 2; i++ {
+		file = append(file, silence...)
+		file = append(file, sound...)
+	}
+	return append(file, bytes.Repeat(sound, 2)...)
+}
+
+func TestVpk(t *testing.T) {
+	v, err := NewFromData(bytes.NewReader(vpkFixture(VPK_MAGIC)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Channels != 2 || v.SampleRate != 22050 || v.Interleave != vag.FRAME_SIZE {
+		t.Fatalf("Wrong header: %+v", v)
+	}
+
+	w, err := v.Wav()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Samples) != 4*vag.FRAME_SAMPLES {
+		t.Fatalf("Wrong samples count %d", len(w.Samples))
+	}
+	for i := 0; i < len(w.Samples); i += 2 {
+		if w.Samples[i] != 0 || w.Samples[i+1] == 0 {
+			t.Fatalf("Channels mixed at sample %d: %d %d", i/2, w.Samples[i], w.Samples[i+1])
+		}
+	}
+	if w.Loop == nil || w.Loop.Start != vag.FRAME_SAMPLES || w.Loop.End != 2*vag.FRAME_SAMPLES {
+		t.Fatalf("Wrong loop %+v", w.Loop)
+	}
+}
+
+func TestVpkWrongMagic(t *testing.T) {
+	if _, err := NewFromData(bytes.NewReader(vpkFixture("VPK "))); err == nil {
+		t.Fatal("No error for byte-swapped magic")
+	}
+}
@@ -0,0 +1,294 @@
+package wad
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Node which must be extracted before dependent node.
+// If Name is empty, all sibling nodes with Format are dependencies
+type Dependency struct {
+	Name   string // found with Find(Name, true)
+	Format uint32
+}
+
+// Optional interface of exporter, for formats which use
+// files extracted from other nodes
+type WadFormatDependencies interface {
+	Dependencies(wadnode *WadNode) ([]Dependency, error)
+}
+
+// Problem found when ordering nodes for extraction
+type Diagnostic struct {
+	Node    *WadNode
+	Message string
+}
+
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("'%s': %s", d.Node.Path, d.Message)
+}
+
+// Collects dependencies declared by exporters of node format
+func (nd *WadNode) dependencies() ([]Dependency, error) {
+	deps := make([]Dependency, 0)
+	for _, ex := range nd.exporters() {
+		if deper, ok := ex.(WadFormatDependencies); ok {
+			exdeps, err := deper.Dependencies(nd)
+			if err != nil {
+				return deps, err
+			}
+			deps = append(deps, exdeps...)
+		}
+	}
+	return deps, nil
+}
+
+// Returns node link points to, or node itself for data nodes
+func (nd *WadNode) Resolve() *WadNode {
+	if nd.Type == NODE_TYPE_LINK {
+		return nd.LinkTo
+	}
+	return nd
+}
+
+// Returns nodes of dependency. Empty result for named dependency means it is missing
+func (nd *WadNode) resolveDependency(dep Dependency) []*WadNode {
+	if dep.Name != "" {
+		if dn := nd.Find(dep.Name, true); dn != nil {
+			if dn = dn.Resolve(); dn != nil && dn.Type == NODE_TYPE_DATA {
+				return []*WadNode{dn}
+			}
+		}
+		return nil
+	}
+
+	siblings := nd.Wad.Nodes
+	if nd.Parent != nil {
+		siblings = nd.Parent.SubNodes
+	}
+
+	res := make([]*WadNode, 0)
+	for _, sn := range siblings {
+		if sn = sn.Resolve(); sn != nil && sn != nd && sn.Type == NODE_TYPE_DATA && sn.Format == dep.Format {
+			res = append(res, sn)
+		}
+	}
+	return res
+}
+
+// Returns not extracted data nodes of tree in order of extraction: every node
+// placed after its dependencies. Nodes with missing or cyclic dependencies (or
+// dependencies exporter cannot get), and nodes which depend on them, returned
+// as skipped and reported as diagnostics
+func (wad *Wad) ExtractOrder(nodes []*WadNode) (order []*WadNode, skipped []*WadNode, diags []*Diagnostic) {
+	order, skipped, diags, _ = wad.extractGraph(nodes)
+	return
+}
+
+// Same as ExtractOrder, also returns dependencies of every ordered node
+func (wad *Wad) extractGraph(nodes []*WadNode) (order []*WadNode, skipped []*WadNode,
+	diags []*Diagnostic, depends map[*WadNode][]*WadNode) {
+	const (
+		STATE_NEW = iota
+		STATE_VISITING
+		STATE_DONE
+		STATE_SKIPPED
+	)
+
+	state := make(map[*WadNode]int)
+	order = make([]*WadNode, 0)
+	skipped = make([]*WadNode, 0)
+	diags = make([]*Diagnostic, 0)
+	depends = make(map[*WadNode][]*WadNode)
+
+	diag := func(nd *WadNode, format string, args ...interface{}) {
+		diags = append(diags, &Diagnostic{Node: nd, Message: fmt.Sprintf(format, args...)})
+	}
+
+	var visit func(nd *WadNode) bool
+	visit = func(nd *WadNode) bool {
+		switch state[nd] {
+		case STATE_DONE:
+			return true
+		case STATE_SKIPPED:
+			return false
+		}
+
+		state[nd] = STATE_VISITING
+		ok := true
+
+		if !nd.Extracted() {
+			deps, err := nd.dependencies()
+			if err != nil {
+				diag(nd, "cannot get dependencies: %v", err)
+				ok = false
+			}
+
+			for _, dep := range deps {
+				dnodes := nd.resolveDependency(dep)
+				if len(dnodes) == 0 && dep.Name != "" {
+					diag(nd, "missing dependency '%s'", dep.Name)
+					ok = false
+				}
+
+				for _, dn := range dnodes {
+					if state[dn] == STATE_VISITING {
+						diag(nd, "dependency cycle with '%s'", dn.Path)
+						ok = false
+					} else if !visit(dn) {
+						diag(nd, "dependency '%s' skipped", dn.Path)
+						ok = false
+					} else {
+						depends[nd] = append(depends[nd], dn)
+					}
+				}
+			}
+		}
+
+		if ok {
+			state[nd] = STATE_DONE
+			if !nd.Extracted() {
+				order = append(order, nd)
+			}
+		} else {
+			state[nd] = STATE_SKIPPED
+			skipped = append(skipped, nd)
+		}
+		return ok
+	}
+
+	var walk func(nodes []*WadNode)
+	walk = func(nodes []*WadNode) {
+		for _, nd := range nodes {
+			if nd.Type == NODE_TYPE_DATA {
+				walk(nd.SubNodes)
+				visit(nd)
+			}
+		}
+	}
+	walk(nodes)
+
+	return order, skipped, diags, depends
+}
+
+// Extracts nodes with subnodes in dependency order
+func (wad *Wad) extractNodes(nodes []*WadNode, outdir string, opts ExtractOptions) (*Report, error) {
+	order, skipped, diags, depends := wad.extractGraph(nodes)
+
+	report := newReport(opts.Dump, wad.Version)
+	report.Diagnostics = diags
+	for _, d := range diags {
+		log.Printf("Extraction diagnostic %s", d)
+	}
+	for _, nd := range skipped {
+		reasons := make([]string, 0)
+		for _, d := range diags {
+			if d.Node == nd {
+				reasons = append(reasons, d.Message)
+			}
+		}
+		report.skip(nd, strings.Join(reasons, "; "))
+	}
+
+	// node extracted (or queued) when all its dependencies extracted
+	pending := make(map[*WadNode]int)
+	dependents := make(map[*WadNode][]*WadNode)
+	for _, nd := range order {
+		for _, dn := range depends[nd] {
+			if !dn.Extracted() {
+				pending[nd]++
+				dependents[dn] = append(dependents[dn], nd)
+			}
+		}
+	}
+
+	// dependents of failed node skipped, like nodes with graph problems
+	failedDeps := make(map[*WadNode]bool)
+	var skipDependents func(nd *WadNode, format string)
+	skipDependents = func(nd *WadNode, format string) {
+		for _, dn := range dependents[nd] {
+			if failedDeps[dn] {
+				continue
+			}
+			failedDeps[dn] = true
+			d := &Diagnostic{Node: dn, Message: fmt.Sprintf(format, nd.Path)}
+			log.Printf("Extraction diagnostic %s", d)
+			report.Diagnostics = append(report.Diagnostics, d)
+			report.skip(dn, d.Message)
+			skipDependents(dn, "dependency '%s' skipped")
+		}
+	}
+
+	// returns false if extraction must be stopped
+	result := func(nd *WadNode, err error) bool {
+		if err == nil {
+			report.success(nd)
+			return true
+		}
+		if opts.KeepGoing {
+			log.Printf("%v", err)
+		}
+		report.fail(nd, err)
+		if opts.KeepGoing {
+			skipDependents(nd, "dependency '%s' failed")
+		}
+		return opts.KeepGoing
+	}
+
+	if opts.Jobs <= 1 {
+		for _, nd := range order {
+			if failedDeps[nd] {
+				continue
+			}
+			if err := nd.extractData(outdir, opts.Dump); !result(nd, err) {
+				return report, err
+			}
+		}
+		return report, nil
+	}
+
+	type extractResult struct {
+		nd  *WadNode
+		err error
+	}
+	work := make(chan *WadNode, len(order))
+	results := make(chan extractResult)
+
+	for i := 0; i < opts.Jobs; i++ {
+		go func() {
+			for nd := range work {
+				results <- extractResult{nd: nd, err: nd.extractData(outdir, opts.Dump)}
+			}
+		}()
+	}
+	defer close(work)
+
+	queued := 0
+	for _, nd := range order {
+		if pending[nd] == 0 {
+			work <- nd
+			queued++
+		}
+	}
+
+	var firstErr error
+	for done := 0; done < queued; done++ {
+		res := <-results
+		if !result(res.nd, res.err) && firstErr == nil {
+			firstErr = res.err
+		}
+		if firstErr != nil || res.err != nil {
+			// wait for already queued nodes, dependents of failed node never queued
+			continue
+		}
+
+		for _, dn := range dependents[res.nd] {
+			if pending[dn]--; pending[dn] == 0 && !failedDeps[dn] {
+				work <- dn
+				queued++
+			}
+		}
+	}
+	return report, firstErr
+}
@@ -0,0 +1,47 @@
+package wad
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+type testDepsExporter struct {
+	exported []string
+}
+
+func (*testDepsExporter) Dependencies(nd *WadNode) ([]Dependency, error) {
+	return nil, errors.New("sibling not decoded")
+}
+
+func (e *testDepsExporter) Export(nd *WadNode, decoded interface{}, outfname string) error {
+	e.exported = append(e.exported, nd.Path)
+	return nil
+}
+
+func TestDependenciesErrorSkipsNode(t *testing.T) {
+	wad, err := NewWad(bytes.NewReader(testWad(utils.GAME_VERSION_GOW_1)), utils.GAME_VERSION_GOW_1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd := wad.FindPath("GRP/A")
+
+	ex := &testDepsExporter{}
+	RegisterExporter(utils.GAME_VERSION_GOW_1, nd.Format, ex)
+	defer delete(wadExporter, formatKey{utils.GAME_VERSION_GOW_1, nd.Format})
+
+	report, err := wad.ExtractReport(t.TempDir(), ExtractOptions{KeepGoing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ex.exported) != 0 {
+		t.Fatalf("Node exported: %v", ex.exported)
+	}
+	if len(report.Failures) != 1 || report.Failures[0].Node != nd ||
+		!strings.Contains(report.Failures[0].Err.Error(), "sibling not decoded") {
+		t.Fatalf("Wrong failures %v", report.Failures)
+	}
+}
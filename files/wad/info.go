@@ -0,0 +1,92 @@
+package wad
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Machine-readable description of node and its subnodes
+type NodeInfo struct {
+	Name           string      `json:"name"`
+	Path           string      `json:"path"`
+	Type           string      `json:"type"` // "data" or "link"
+	Format         uint32      `json:"format"`
+	FormatHex      string      `json:"format_hex"`
+	Decoder        string      `json:"decoder,omitempty"`
+	Exporter       string      `json:"exporter,omitempty"`
+	Size           uint32      `json:"size"`
+	DataStart      uint32      `json:"data_start"`
+	Depth          int         `json:"depth"`
+	LinkTo         string      `json:"link_to,omitempty"`
+	UnresolvedLink bool        `json:"unresolved_link,omitempty"`
+	SubNodes       []*NodeInfo `json:"nodes,omitempty"`
+}
+
+// Machine-readable description of wad
+type WadInfo struct {
+	Version int         `json:"version"`
+	Nodes   []*NodeInfo `json:"nodes"`
+}
+
+func handlerName(handler interface{}) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", handler), "*")
+}
+
+// Returns name of decoder registered for version and format, like "gfx.GFX"
+func DecoderName(version int, format_magic uint32) string {
+	if dec, ok := wadDecoder[formatKey{version, format_magic}]; ok {
+		return handlerName(dec)
+	}
+	return ""
+}
+
+// Returns comma separated names of exporters registered for version and format
+func ExporterName(version int, format_magic uint32) string {
+	names := make([]string, 0)
+	for _, ex := range wadExporter[formatKey{version, format_magic}] {
+		names = append(names, handlerName(ex))
+	}
+	return strings.Join(names, ",")
+}
+
+func (nd *WadNode) Info() *NodeInfo {
+	info := &NodeInfo{
+		Name:      nd.Name,
+		Path:      nd.Path,
+		Format:    nd.Format,
+		FormatHex: fmt.Sprintf("0x%.8x", nd.Format),
+		Size:      nd.Size,
+		DataStart: nd.DataStart,
+		Depth:     nd.Depth,
+	}
+
+	switch nd.Type {
+	case NODE_TYPE_DATA:
+		info.Type = "data"
+		info.Decoder = DecoderName(nd.Wad.Version, nd.Format)
+		info.Exporter = ExporterName(nd.Wad.Version, nd.Format)
+	case NODE_TYPE_LINK:
+		info.Type = "link"
+		if nd.LinkTo != nil {
+			info.LinkTo = nd.LinkTo.Path
+		} else {
+			info.UnresolvedLink = true
+		}
+	}
+
+	for _, sn := range nd.SubNodes {
+		info.SubNodes = append(info.SubNodes, sn.Info())
+	}
+	return info
+}
+
+func (wad *Wad) Info() *WadInfo {
+	info := &WadInfo{
+		Version: wad.Version,
+		Nodes:   make([]*NodeInfo, len(wad.Nodes)),
+	}
+	for i, nd := range wad.Nodes {
+		info.Nodes[i] = nd.Info()
+	}
+	return info
+}
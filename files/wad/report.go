@@ -0,0 +1,128 @@
+package wad
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Node which was not extracted
+type Failure struct {
+	Node   *WadNode
+	Format uint32
+	Err    error
+}
+
+func (f *Failure) String() string {
+	return fmt.Sprintf("'%s' format 0x%.8x: %v", f.Node.Path, f.Format, f.Err)
+}
+
+// Counts of nodes of one format
+type FormatStat struct {
+	Extracted int
+	Failed    int
+	Skipped   int // not extracted because of dependency problems
+}
+
+// Result of extraction. Nodes without decoder and exporters counted only when dumping
+type Report struct {
+	Formats     map[uint32]*FormatStat
+	Failures    []*Failure // failed and skipped nodes
+	Diagnostics []*Diagnostic
+	Version     int // game version of wad, utils.GAME_VERSION_*
+
+	dump bool
+}
+
+func newReport(dump bool, version int) *Report {
+	return &Report{
+		Version:     version,
+		Formats:     make(map[uint32]*FormatStat),
+		Failures:    make([]*Failure, 0),
+		Diagnostics: make([]*Diagnostic, 0),
+		dump:        dump,
+	}
+}
+
+// Returns stat of format, nil if nodes of format are not counted
+func (r *Report) stat(nd *WadNode) *FormatStat {
+	if _, f := nd.decoder(); !f && len(nd.exporters()) == 0 && !r.dump {
+		return nil
+	}
+	st, ok := r.Formats[nd.Format]
+	if !ok {
+		st = &FormatStat{}
+		r.Formats[nd.Format] = st
+	}
+	return st
+}
+
+func (r *Report) success(nd *WadNode) {
+	if st := r.stat(nd); st != nil {
+		st.Extracted++
+	}
+}
+
+func (r *Report) fail(nd *WadNode, err error) {
+	if st := r.stat(nd); st != nil {
+		st.Failed++
+	}
+	r.Failures = append(r.Failures, &Failure{Node: nd, Format: nd.Format, Err: err})
+}
+
+func (r *Report) skip(nd *WadNode, reason string) {
+	if st := r.stat(nd); st != nil {
+		st.Skipped++
+	}
+	r.Failures = append(r.Failures, &Failure{Node: nd, Format: nd.Format,
+		Err: fmt.Errorf("Skipped because of dependency problems: %s", reason)})
+}
+
+// True if any node failed or was skipped
+func (r *Report) Failed() bool {
+	return len(r.Failures) != 0
+}
+
+// Writes counts per format and list of failures
+func (r *Report) WriteSummary(w io.Writer) error {
+	formats := make([]uint32, 0, len(r.Formats))
+	for format := range r.Formats {
+		formats = append(formats, format)
+	}
+	sort.Slice(formats, func(i, j int) bool { return formats[i] < formats[j] })
+
+	total := FormatStat{}
+	if _, err := fmt.Fprintln(w, "Extraction summary:"); err != nil {
+		return err
+	}
+	for _, format := range formats {
+		st := r.Formats[format]
+		name := DecoderName(r.Version, format)
+		if name == "" {
+			name = "-"
+		}
+		if _, err := fmt.Fprintf(w, "  0x%.8x %-16s extracted: %d failed: %d skipped: %d\n",
+			format, name, st.Extracted, st.Failed, st.Skipped); err != nil {
+			return err
+		}
+		total.Extracted += st.Extracted
+		total.Failed += st.Failed
+		total.Skipped += st.Skipped
+	}
+	if _, err := fmt.Fprintf(w, "  total extracted: %d failed: %d skipped: %d\n",
+		total.Extracted, total.Failed, total.Skipped); err != nil {
+		return err
+	}
+
+	if len(r.Failures) != 0 {
+		if _, err := fmt.Fprintln(w, "Failures:"); err != nil {
+			return err
+		}
+		for _, f := range r.Failures {
+			if _, err := fmt.Fprintf(w, "  %s\n", f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
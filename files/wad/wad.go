@@ -1,6 +1,7 @@
 package wad
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/mogaika/god_of_war_tools/utils"
 )
@@ -19,6 +21,28 @@ const (
 	NODE_TYPE_LINK
 )
 
+// GoW1 tags
+const (
+	TAG_GOW1_ENTITY_COUNT = 0x18
+	TAG_GOW1_DATA         = 0x1e
+	TAG_GOW1_GROUP_START  = 0x28
+	TAG_GOW1_GROUP_END    = 0x32
+	TAG_GOW1_DATA_START   = 0x29a
+	TAG_GOW1_HEADER_START = 0x378
+	TAG_GOW1_HEADER_POP   = 0x3e7
+)
+
+// GoW2 tags
+const (
+	TAG_GOW2_ENTITY_COUNT = 0
+	TAG_GOW2_DATA         = 0x01
+	TAG_GOW2_GROUP_START  = 0x02
+	TAG_GOW2_GROUP_END    = 0x03
+	TAG_GOW2_DATA_START   = 0x13
+	TAG_GOW2_HEADER_START = 0x15
+	TAG_GOW2_HEADER_POP   = 0x16
+)
+
 type WadNode struct {
 	Name     string // can be empty
 	Path     string
@@ -33,30 +57,67 @@ type WadNode struct {
 	Format    uint32 // first 4 bytes of data
 	DataStart uint32
 
-	// Export caches
-	Extracted      bool
-	Cache          interface{}
-	ExtractedNames []string
+	// Export caches, guarded by cacheMutex for parallel extraction
+	cacheMutex     sync.RWMutex
+	extracted      bool
+	cache          interface{}
+	extractedNames []string
 
 	// NODE_TYPE_LINK
 	LinkTo *WadNode
+
+	// replaced data, used instead of original data if not nil
+	newData []byte
 }
 
 type Wad struct {
 	Nodes  []*WadNode
 	reader io.ReaderAt
+	Tags   []*Tag // every tag of file in stream order
 
 	Version int // utils.GAME_VERSION_*
 }
 
+// Parses node data into typed object (like *gfx.GFX) without touching filesystem
+type WadFormatDecoder interface {
+	Decode(wadnode *WadNode) (interface{}, error)
+}
+
+// Writes decoded object of node into files. Exporter can implement
+// WadFormatDependencies, if it needs other nodes extracted before
 type WadFormatExporter interface {
-	ExtractFromNode(wadnode *WadNode, outfname string) error
+	Export(wadnode *WadNode, decoded interface{}, outfname string) error
+}
+
+// Different games can use same magic for different layouts,
+// so handlers registered for game version and format magic
+type formatKey struct {
+	version int // utils.GAME_VERSION_*
+	format  uint32
+}
+
+var wadDecoder map[formatKey]WadFormatDecoder = make(map[formatKey]WadFormatDecoder, 0)
+var wadExporter map[formatKey][]WadFormatExporter = make(map[formatKey][]WadFormatExporter, 0)
+
+func RegisterDecoder(version int, format_magic uint32, decoder WadFormatDecoder) {
+	wadDecoder[formatKey{version, format_magic}] = decoder
+}
+
+// One format can have several exporters, they called in order of registration
+func RegisterExporter(version int, format_magic uint32, exporter WadFormatExporter) {
+	key := formatKey{version, format_magic}
+	wadExporter[key] = append(wadExporter[key], exporter)
 }
 
-var wadExporter map[uint32]WadFormatExporter = make(map[uint32]WadFormatExporter, 0)
+// Returns decoder of node format for version of wad
+func (nd *WadNode) decoder() (WadFormatDecoder, bool) {
+	dec, f := wadDecoder[formatKey{nd.Wad.Version, nd.Format}]
+	return dec, f
+}
 
-func PregisterExporter(format_magic uint32, exporter WadFormatExporter) {
-	wadExporter[format_magic] = exporter
+// Returns exporters of node format for version of wad
+func (nd *WadNode) exporters() []WadFormatExporter {
+	return wadExporter[formatKey{nd.Wad.Version, nd.Format}]
 }
 
 func (nd *WadNode) StringPrefixed(prefix string) string {
@@ -105,14 +166,94 @@ func (nd *WadNode) Find(name string, uptree bool) *WadNode {
 	return nil
 }
 
+// True if node data already extracted
+func (nd *WadNode) Extracted() bool {
+	nd.cacheMutex.RLock()
+	defer nd.cacheMutex.RUnlock()
+	return nd.extracted
+}
+
+func (nd *WadNode) setExtracted() {
+	nd.cacheMutex.Lock()
+	defer nd.cacheMutex.Unlock()
+	nd.extracted = true
+}
+
+// Parsed data stored by exporter
+func (nd *WadNode) Cache() interface{} {
+	nd.cacheMutex.RLock()
+	defer nd.cacheMutex.RUnlock()
+	return nd.cache
+}
+
+func (nd *WadNode) SetCache(cache interface{}) {
+	nd.cacheMutex.Lock()
+	defer nd.cacheMutex.Unlock()
+	nd.cache = cache
+}
+
+// Files created by exporter
+func (nd *WadNode) ExtractedNames() []string {
+	nd.cacheMutex.RLock()
+	defer nd.cacheMutex.RUnlock()
+	return nd.extractedNames
+}
+
+func (nd *WadNode) AddExtractedNames(names ...string) {
+	nd.cacheMutex.Lock()
+	defer nd.cacheMutex.Unlock()
+	nd.extractedNames = append(nd.extractedNames, names...)
+}
+
+// Returns decoded data of node, like *gfx.GFX. Result cached in node
+func (nd *WadNode) Decode() (interface{}, error) {
+	if cache := nd.Cache(); cache != nil {
+		return cache, nil
+	}
+	if nd.Type != NODE_TYPE_DATA {
+		return nil, errors.New("Node must be data for decoding")
+	}
+
+	dec, f := nd.decoder()
+	if !f {
+		return nil, fmt.Errorf("Decoder for format 0x%.8x of game version %d not registered", nd.Format, nd.Wad.Version)
+	}
+
+	decoded, err := dec.Decode(nd)
+	if err != nil {
+		return nil, err
+	}
+	nd.SetCache(decoded)
+	return decoded, nil
+}
+
 func (nd *WadNode) DataReader() (*io.SectionReader, error) {
 	if nd.Type != NODE_TYPE_DATA {
 		return nil, errors.New("Node must be data for reading")
+	} else if nd.newData != nil {
+		return io.NewSectionReader(bytes.NewReader(nd.newData), 0, int64(len(nd.newData))), nil
 	} else {
 		return io.NewSectionReader(nd.Wad.reader, int64(nd.DataStart), int64(nd.Size)), nil
 	}
 }
 
+// Replaces data of node. New data used by readers and wad writer
+func (nd *WadNode) SetData(data []byte) error {
+	if nd.Type != NODE_TYPE_DATA {
+		return errors.New("Node must be data for replacing")
+	}
+	// minimal size of data == 4, for storing data format
+	if len(data) < 4 {
+		return errors.New("Data too small")
+	}
+
+	nd.newData = data
+	nd.Size = uint32(len(data))
+	nd.Format = binary.LittleEndian.Uint32(data[0:4])
+	nd.SetCache(nil)
+	return nil
+}
+
 func (nd *WadNode) DataRead() ([]byte, error) {
 	rdr, err := nd.DataReader()
 	if err != nil {
@@ -128,46 +269,55 @@ func (nd *WadNode) DataRead() ([]byte, error) {
 	}
 }
 
+// Extracts node and its subnodes. Dependencies extracted first
 func (nd *WadNode) Extract(outdir string, dump bool) error {
-	if nd.Type == NODE_TYPE_DATA {
-		myPath := path.Join(outdir, strings.Replace(nd.Path, ":", "-", -1))
+	_, err := nd.Wad.extractNodes([]*WadNode{nd}, outdir, ExtractOptions{Dump: dump})
+	return err
+}
 
-		for _, sn := range nd.SubNodes {
-			if err := sn.Extract(outdir, dump); err != nil {
-				return err
-			}
-		}
+// Extracts data of node without subnodes
+func (nd *WadNode) extractData(outdir string, dump bool) error {
+	if nd.Type != NODE_TYPE_DATA || nd.Extracted() {
+		return nil
+	}
+
+	myPath := path.Join(outdir, strings.Replace(nd.Path, ":", "-", -1))
 
-		//	log.Printf("extracting '%s' 0x%x : 0x%x", nd.Path, nd.Format, nd.Size)
-		if !nd.Extracted {
-			if dump {
-				dumpfname := myPath + ".dump"
+	//	log.Printf("extracting '%s' 0x%x : 0x%x", nd.Path, nd.Format, nd.Size)
+	if dump {
+		dumpfname := myPath + ".dump"
 
-				rdr, derr := nd.DataReader()
+		rdr, derr := nd.DataReader()
+		if derr == nil {
+			if derr = os.MkdirAll(path.Dir(dumpfname), 0777); derr == nil {
+				var f *os.File
+				f, derr = os.Create(dumpfname)
 				if derr == nil {
-					if derr = os.MkdirAll(path.Dir(dumpfname), 0777); derr == nil {
-						var f *os.File
-						f, derr = os.Create(dumpfname)
-						if derr == nil {
-							defer f.Close()
-							_, derr = io.Copy(f, rdr)
-						}
-					}
-				}
-				if derr != nil {
-					fmt.Errorf("Error when dumping '%s' -> '%s': %v", nd.Path, dumpfname, derr)
+					defer f.Close()
+					_, derr = io.Copy(f, rdr)
 				}
 			}
+		}
+		if derr != nil {
+			return fmt.Errorf("Error when dumping '%s' -> '%s': %v", nd.Path, dumpfname, derr)
+		}
+	}
 
-			if ex, f := wadExporter[nd.Format]; f {
-				if err := ex.ExtractFromNode(nd, myPath); err != nil {
-					return fmt.Errorf("Error when extracting '%s': %v", nd.Path, err)
-				}
-			}
-			nd.Extracted = true
+	var decoded interface{}
+	if _, f := nd.decoder(); f {
+		var err error
+		if decoded, err = nd.Decode(); err != nil {
+			return fmt.Errorf("Error when decoding '%s': %v", nd.Path, err)
 		}
 	}
 
+	for _, ex := range nd.exporters() {
+		if err := ex.Export(nd, decoded, myPath); err != nil {
+			return fmt.Errorf("Error when extracting '%s': %v", nd.Path, err)
+		}
+	}
+	nd.setExtracted()
+
 	return nil
 }
 
@@ -180,13 +330,40 @@ func (wad *Wad) Find(name string) *WadNode {
 	return nil
 }
 
-func (wad *Wad) Extract(outdir string, dump bool) error {
-	for _, nd := range wad.Nodes {
-		if err := nd.Extract(outdir, dump); err != nil {
-			return err
+// Finds node by full path (like 'GROUP/NODE'). First node with path returned
+func (wad *Wad) FindPath(p string) *WadNode {
+	var find func(nodes []*WadNode) *WadNode
+	find = func(nodes []*WadNode) *WadNode {
+		for _, nd := range nodes {
+			if nd.Path == p {
+				return nd
+			}
+			if strings.HasPrefix(p, nd.Path+"/") {
+				if res := find(nd.SubNodes); res != nil {
+					return res
+				}
+			}
 		}
+		return nil
 	}
-	return nil
+	return find(wad.Nodes)
+}
+
+// Extracts all nodes. Nodes extracted after their dependencies
+func (wad *Wad) Extract(outdir string, dump bool) error {
+	_, err := wad.extractNodes(wad.Nodes, outdir, ExtractOptions{Dump: dump})
+	return err
+}
+
+type ExtractOptions struct {
+	Dump      bool // dump raw data of nodes (.dump)
+	KeepGoing bool // do not stop on node errors, they collected in report instead
+	Jobs      int  // count of nodes extracted in parallel, <= 1 for sequential extraction
+}
+
+// Extracts all nodes and returns report of extraction
+func (wad *Wad) ExtractReport(outdir string, opts ExtractOptions) (*Report, error) {
+	return wad.extractNodes(wad.Nodes, outdir, opts)
 }
 
 func (wad *Wad) newNode(parent *WadNode, name string, nodeType int) *WadNode {
@@ -208,24 +385,27 @@ func (wad *Wad) newNode(parent *WadNode, name string, nodeType int) *WadNode {
 }
 
 func (wad *Wad) DetectVersion() (int, error) {
-	wad.Version = utils.GAME_VERSION_UNKNOWN
+	var err error
+	wad.Version, err = DetectVersion(wad.reader)
+	return wad.Version, err
+}
 
+// Detects game version by first tag of wad file
+func DetectVersion(f io.ReaderAt) (int, error) {
 	var buffer [4]byte
-	_, err := wad.reader.ReadAt(buffer[:], 0)
+	_, err := f.ReadAt(buffer[:], 0)
 	if err != nil {
-		return wad.Version, err
+		return utils.GAME_VERSION_UNKNOWN, err
 	}
 
 	first_tag := binary.LittleEndian.Uint32(buffer[:])
 	switch first_tag {
-	case 0x378:
-		wad.Version = utils.GAME_VERSION_GOW_1
-	case 0x15:
-		wad.Version = utils.GAME_VERSION_GOW_2
-	default:
-		return wad.Version, errors.New("Cannot detect version")
+	case TAG_GOW1_HEADER_START:
+		return utils.GAME_VERSION_GOW_1, nil
+	case TAG_GOW2_HEADER_START:
+		return utils.GAME_VERSION_GOW_2, nil
 	}
-	return wad.Version, nil
+	return utils.GAME_VERSION_UNKNOWN, errors.New("Cannot detect version")
 }
 
 func NewWad(f io.ReaderAt, version int) (wad *Wad, err error) {
@@ -275,40 +455,52 @@ func NewWad(f io.ReaderAt, version int) (wad *Wad, err error) {
 		size := binary.LittleEndian.Uint32(item[4:8])
 		name := utils.BytesToString(item[8:32])
 
+		rec := &Tag{
+			Index:   len(wad.Tags),
+			Tag:     tag,
+			Flags:   binary.LittleEndian.Uint16(item[2:4]),
+			Size:    size,
+			Name:    name,
+			Pos:     pos,
+			DataPos: data_pos,
+		}
+		copy(rec.header[:], item)
+		wad.Tags = append(wad.Tags, rec)
+
 		switch wad.Version {
 		case utils.GAME_VERSION_GOW_2:
 			switch tag {
-			case 0x01: // file data packet
+			case TAG_GOW2_DATA: // file data packet
 				pack_id = PACK_DATA
-			case 0x02: // file header group start
+			case TAG_GOW2_GROUP_START: // file header group start
 				pack_id = PACK_GROUP_START
-			case 0x03: // file header group end
+			case TAG_GOW2_GROUP_END: // file header group end
 				pack_id = PACK_GROUP_END
-			case 0x13: // file data start
+			case TAG_GOW2_DATA_START: // file data start
 				pack_id = PACK_STUFF
-			case 0x15: // file header start
+			case TAG_GOW2_HEADER_START: // file header start
 				pack_id = PACK_STUFF
-			case 0x16: // file header pop heap
+			case TAG_GOW2_HEADER_POP: // file header pop heap
 				pack_id = PACK_STUFF
-			case 0: // entity count
+			case TAG_GOW2_ENTITY_COUNT: // entity count
 				size = 0
 				pack_id = PACK_STUFF
 			}
 		case utils.GAME_VERSION_GOW_1:
 			switch tag {
-			case 0x1e: // file data packet
+			case TAG_GOW1_DATA: // file data packet
 				pack_id = PACK_DATA
-			case 0x28: // file data group start
+			case TAG_GOW1_GROUP_START: // file data group start
 				pack_id = PACK_GROUP_START
-			case 0x32: // file data group end
+			case TAG_GOW1_GROUP_END: // file data group end
 				pack_id = PACK_GROUP_END
-			case 0x378: // file header start
+			case TAG_GOW1_HEADER_START: // file header start
 				pack_id = PACK_STUFF
-			case 0x3e7: // file header pop heap
+			case TAG_GOW1_HEADER_POP: // file header pop heap
 				pack_id = PACK_STUFF
-			case 0x29a: // file data start
+			case TAG_GOW1_DATA_START: // file data start
 				pack_id = PACK_STUFF
-			case 0x18: // entity count
+			case TAG_GOW1_ENTITY_COUNT: // entity count
 				size = 0
 				pack_id = PACK_STUFF
 			}
@@ -364,6 +556,8 @@ func NewWad(f io.ReaderAt, version int) (wad *Wad, err error) {
 			}
 			node.Size = size
 			node.DataStart = uint32(data_pos)
+			rec.Node = node
+			rec.parent = currentNode
 
 			if currentNode == nil {
 				wad.Nodes = append(wad.Nodes, node)
@@ -386,6 +580,7 @@ func NewWad(f io.ReaderAt, version int) (wad *Wad, err error) {
 			}
 		*/
 
+		rec.DataSize = size
 		off := (size + 15) & (15 ^ math.MaxUint32)
 		pos = int64(off) + pos + 0x20
 	}
@@ -0,0 +1,156 @@
+package wad
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+const TAG_HEADER_SIZE = 0x20
+
+// Raw tag record of wad stream. Every tag of file preserved,
+// including non-data and unknown tags
+type Tag struct {
+	Index    int    // position in tags stream
+	Tag      uint16 // TAG_GOW*_*
+	Flags    uint16 // unknown field after tag id
+	Size     uint32 // raw size field, entity count for entity count tags
+	Name     string
+	Pos      int64  // position of tag header in file
+	DataPos  int64  // position of payload in file
+	DataSize uint32 // size of payload (zero for entity count tags)
+	Node     *WadNode
+
+	header [TAG_HEADER_SIZE]byte // original tag header
+	parent *WadNode              // group of node in original file, nil for root
+}
+
+var tagNames = map[int]map[uint16]string{
+	utils.GAME_VERSION_GOW_1: {
+		TAG_GOW1_ENTITY_COUNT: "entity count",
+		TAG_GOW1_DATA:         "data",
+		TAG_GOW1_GROUP_START:  "group start",
+		TAG_GOW1_GROUP_END:    "group end",
+		TAG_GOW1_DATA_START:   "data start",
+		TAG_GOW1_HEADER_START: "header start",
+		TAG_GOW1_HEADER_POP:   "header pop heap",
+	},
+	utils.GAME_VERSION_GOW_2: {
+		TAG_GOW2_ENTITY_COUNT: "entity count",
+		TAG_GOW2_DATA:         "data",
+		TAG_GOW2_GROUP_START:  "group start",
+		TAG_GOW2_GROUP_END:    "group end",
+		TAG_GOW2_DATA_START:   "data start",
+		TAG_GOW2_HEADER_START: "header start",
+		TAG_GOW2_HEADER_POP:   "header pop heap",
+	},
+}
+
+// Returns description of known tag for game version or "unknown"
+func TagName(version int, tag uint16) string {
+	if name, ok := tagNames[version][tag]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+func (t *Tag) StringVersion(version int) string {
+	res := fmt.Sprintf("%.4d pos: 0x%.8x tag: 0x%.4x flags: 0x%.4x size: 0x%.6x data: 0x%.8x '%s' (%s)",
+		t.Index, t.Pos, t.Tag, t.Flags, t.Size, t.DataPos, t.Name, TagName(version, t.Tag))
+	if t.Node != nil {
+		res += " -> " + t.Node.Path
+	}
+	return res
+}
+
+func alignedSize(size uint32) int64 {
+	return (int64(size) + 15) &^ 15
+}
+
+func (t *Tag) write(w io.Writer, r io.ReaderAt) error {
+	header := t.header
+	size := t.DataSize
+
+	var data []byte
+	if t.Node != nil && t.Node.newData != nil {
+		data = t.Node.newData
+		size = uint32(len(data))
+		binary.LittleEndian.PutUint32(header[4:8], size)
+	}
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+
+	if data == nil {
+		// payload and padding copied as is, end of file can be not aligned
+		_, err := io.Copy(w, io.NewSectionReader(r, t.DataPos, alignedSize(size)))
+		return err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, alignedSize(size)-int64(size)))
+	return err
+}
+
+// Returns error if nodes tree differs from tags of original file
+func (wad *Wad) checkTree() error {
+	children := make(map[*WadNode][]*WadNode)
+	for _, t := range wad.Tags {
+		if t.Node == nil {
+			continue
+		}
+		if t.Node.Name != t.Name {
+			return fmt.Errorf("Node '%s' renamed to '%s'", t.Name, t.Node.Name)
+		}
+		children[t.parent] = append(children[t.parent], t.Node)
+	}
+
+	same := func(a, b []*WadNode) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	if !same(wad.Nodes, children[nil]) {
+		return errors.New("Root nodes changed")
+	}
+	for _, t := range wad.Tags {
+		if t.Node != nil && !same(t.Node.SubNodes, children[t.Node]) {
+			return fmt.Errorf("Sub nodes of '%s' changed", t.Node.Path)
+		}
+	}
+	return nil
+}
+
+// Writes wad with replaced nodes data. Tags, names and
+// order of nodes are same as in original file, payloads
+// aligned to 16 bytes. Unmodified wad written byte by byte.
+// Only data of nodes can be changed: if nodes added, removed,
+// renamed or moved, error returned and nothing written
+func (wad *Wad) Write(w io.Writer) error {
+	if len(wad.Tags) == 0 {
+		return errors.New("Wad has no tags")
+	}
+	if err := wad.checkTree(); err != nil {
+		return fmt.Errorf("Wad tree can not be written: %v", err)
+	}
+
+	for _, t := range wad.Tags {
+		if err := t.write(w, wad.reader); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package wad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/mogaika/god_of_war_tools/utils"
+)
+
+type testTag struct {
+	tag  uint16
+	size uint32 // entity count for entity count tags
+	name string
+	data []byte
+}
+
+// Stream of tags, payloads aligned to 16 bytes except last one
+func testStream(tags []testTag) []byte {
+	var buf bytes.Buffer
+	for i, t := range tags {
+		var header [TAG_HEADER_SIZE]byte
+		binary.LittleEndian.PutUint16(header[0:], t.tag)
+		binary.LittleEndian.PutUint16(header[2:], uint16(i))
+		binary.LittleEndian.PutUint32(header[4:], t.size)
+		copy(header[8:], t.name)
+		buf.Write(header[:])
+		buf.Write(t.data)
+		if i != len(tags)-1 {
+			buf.Write(make([]byte, alignedSize(uint32(len(t.data)))-int64(len(t.data))))
+		}
+	}
+	return buf.Bytes()
+}
+
+func testData(size int, seed byte) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = seed + byte(i)
+	}
+	return b
+}
+
+func testWad(version int) []byte {
+	tags := map[int][]uint16{
+		utils.GAME_VERSION_GOW_1: {TAG_GOW1_HEADER_START, TAG_GOW1_ENTITY_COUNT, TAG_GOW1_HEADER_POP, TAG_GOW1_DATA_START,
+			TAG_GOW1_GROUP_START, TAG_GOW1_DATA, TAG_GOW1_GROUP_END},
+		utils.GAME_VERSION_GOW_2: {TAG_GOW2_HEADER_START, TAG_GOW2_ENTITY_COUNT, TAG_GOW2_HEADER_POP, TAG_GOW2_DATA_START,
+			TAG_GOW2_GROUP_START, TAG_GOW2_DATA, TAG_GOW2_GROUP_END},
+	}[version]
+	headerStart, entityCount, headerPop, dataStart, groupStart, dataTag, groupEnd :=
+		tags[0], tags[1], tags[2], tags[3], tags[4], tags[5], tags[6]
+
+	return testStream([]testTag{
+		{headerStart, 4, "HDR", testData(4, 1)},
+		{entityCount, 3, "", nil},
+		{headerPop, 0, "", nil},
+		{dataStart, 0, "", nil},
+		{dataTag, 16, "TX", testData(16, 2)},
+		{groupStart, 0, "", nil},
+		{dataTag, 5, "GRP", testData(5, 3)},
+		{dataTag, 20, "A", testData(20, 4)},
+		{dataTag, 0, "TX", nil}, // link
+		{groupEnd, 0, "", nil},
+		{dataTag, 6, "LAST", testData(6, 5)},
+	})
+}
+
+func writeWad(wad *Wad) ([]byte, error) {
+	var buf bytes.Buffer
+	err := wad.Write(&buf)
+	return buf.Bytes(), err
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	for _, version := range []int{utils.GAME_VERSION_GOW_1, utils.GAME_VERSION_GOW_2} {
+		src := testWad(version)
+		wad, err := NewWad(bytes.NewReader(src), utils.GAME_VERSION_UNKNOWN)
+		if err != nil {
+			t.Fatalf("Version %d: %v", version, err)
+		}
+		if wad.Version != version {
+			t.Fatalf("Version %d detected as %d", version, wad.Version)
+		}
+
+		grp := wad.FindPath("GRP")
+		if grp == nil || len(grp.SubNodes) != 2 || grp.SubNodes[1].Type != NODE_TYPE_LINK || grp.SubNodes[1].LinkTo != wad.FindPath("TX") {
+			t.Fatalf("Version %d: wrong tree\n%v", version, wad.Nodes)
+		}
+
+		written, err := writeWad(wad)
+		if err != nil {
+			t.Fatalf("Version %d: %v", version, err)
+		}
+		if !bytes.Equal(written, src) {
+			t.Fatalf("Version %d: unmodified wad written with changes", version)
+		}
+
+		newData := testData(37, 9)
+		if err := wad.FindPath("GRP/A").SetData(newData); err != nil {
+			t.Fatal(err)
+		}
+		written, err = writeWad(wad)
+		if err != nil {
+			t.Fatalf("Version %d: %v", version, err)
+		}
+		rewad, err := NewWad(bytes.NewReader(written), version)
+		if err != nil {
+			t.Fatalf("Version %d: %v", version, err)
+		}
+		if len(rewad.Tags) != len(wad.Tags) {
+			t.Fatalf("Version %d: tags count changed %d -> %d", version, len(wad.Tags), len(rewad.Tags))
+		}
+		for i, tag := range rewad.Tags {
+			if tag.Tag != wad.Tags[i].Tag || tag.Flags != wad.Tags[i].Flags || tag.Name != wad.Tags[i].Name {
+				t.Fatalf("Version %d: tag %d changed", version, i)
+			}
+		}
+		for p, want := range map[string][]byte{"GRP/A": newData, "TX": testData(16, 2), "LAST": testData(6, 5)} {
+			if got, err := rewad.FindPath(p).DataRead(); err != nil || !bytes.Equal(got, want) {
+				t.Fatalf("Version %d: data of '%s' wrong after write: %v", version, p, err)
+			}
+		}
+	}
+}
+
+func TestWriteChangedTree(t *testing.T) {
+	changes := map[string]func(wad *Wad){
+		"removed": func(wad *Wad) {
+			grp := wad.FindPath("GRP")
+			grp.SubNodes = grp.SubNodes[:1]
+		},
+		"added": func(wad *Wad) {
+			wad.Nodes = append(wad.Nodes, wad.newNode(nil, "NEW", NODE_TYPE_DATA))
+		},
+		"renamed": func(wad *Wad) {
+			wad.FindPath("LAST").Name = "OTHER"
+		},
+		"reordered": func(wad *Wad) {
+			wad.Nodes[0], wad.Nodes[2] = wad.Nodes[2], wad.Nodes[0]
+		},
+	}
+	for name, change := range changes {
+		wad, err := NewWad(bytes.NewReader(testWad(utils.GAME_VERSION_GOW_1)), utils.GAME_VERSION_GOW_1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		change(wad)
+		if written, err := writeWad(wad); err == nil || len(written) != 0 {
+			t.Errorf("Wad with %s node written", name)
+		}
+	}
+}
@@ -18,6 +18,30 @@ type Command interface {
 var cmds map[string]Command = map[string]Command{
 	"unpack":  &commands.Unpack{},
 	"extract": &commands.Extract{},
+	"pack":    &commands.Pack{},
+	"verify":  &commands.Verify{},
+	"convert": &commands.Convert{},
+}
+
+// Commands with subcommands, like "wad replace"
+var groups map[string]map[string]Command = map[string]map[string]Command{
+	"wad": {
+		"replace": &commands.WadReplace{},
+	},
+	"txr": {
+		"import": &commands.TxrImport{},
+	},
+}
+
+func runCommand(cmdname string, sc Command, args []string) {
+	fs := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	sc.DefineFlags(fs)
+	fs.Parse(args)
+
+	if err := sc.Run(); err != nil {
+		log.Printf("Program exit with error: %v\n", err)
+		os.Exit(2)
+	}
 }
 
 func main() {
@@ -28,6 +52,11 @@ func main() {
 		for i, _ := range cmds {
 			fmt.Printf("  %s\n", i)
 		}
+		for i, group := range groups {
+			for j, _ := range group {
+				fmt.Printf("  %s %s\n", i, j)
+			}
+		}
 	}
 
 	flag.Parse()
@@ -39,14 +68,9 @@ func main() {
 
 	cmdname := flag.Arg(0)
 	if sc, ok := cmds[cmdname]; ok {
-		fs := flag.NewFlagSet(cmdname, flag.ExitOnError)
-		sc.DefineFlags(fs)
-		fs.Parse(flag.Args()[1:])
-
-		if err := sc.Run(); err != nil {
-			log.Printf("Program exit with error: %v\n", err)
-			os.Exit(2)
-		}
+		runCommand(cmdname, sc, flag.Args()[1:])
+	} else if group, ok := groups[cmdname]; ok && flag.NArg() >= 2 && group[flag.Arg(1)] != nil {
+		runCommand(cmdname+" "+flag.Arg(1), group[flag.Arg(1)], flag.Args()[2:])
 	} else {
 		log.Printf("%s is not a valid command", cmdname)
 		flag.Usage()
@@ -0,0 +1,301 @@
+package gs
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Pixel storage modes
+const (
+	PSMCT32 = 0x00
+	PSMCT16 = 0x02
+	PSMT8   = 0x13
+	PSMT4   = 0x14
+	PSMT8H  = 0x1b // 8 bit in bits 24-31 of PSMCT32 pixel
+	PSMT4HL = 0x24 // 4 bit in bits 24-27 of PSMCT32 pixel
+	PSMT4HH = 0x2c // 4 bit in bits 28-31 of PSMCT32 pixel
+)
+
+// Local memory consists of pages, page consists of 32 blocks,
+// block consists of 4 columns
+const (
+	MEMORY_SIZE = 4 * 1024 * 1024
+	PAGE_SIZE   = 8192
+	BLOCK_SIZE  = 256
+	COLUMN_SIZE = 64
+)
+
+// GS local memory. Addresses wrap around size of data, like on real hardware
+type Memory struct {
+	Data []byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{Data: make([]byte, MEMORY_SIZE)}
+}
+
+// Size of page in pixels
+func PageSize(psm int) (int, int, error) {
+	switch psm {
+	case PSMCT32, PSMT8H, PSMT4HL, PSMT4HH:
+		return 64, 32, nil
+	case PSMCT16:
+		return 64, 64, nil
+	case PSMT8:
+		return 128, 64, nil
+	case PSMT4:
+		return 128, 128, nil
+	}
+	return 0, 0, fmt.Errorf("Unsupported pixel storage mode 0x%x", psm)
+}
+
+// Bits per pixel of data in host (linear) order
+func BitsPerPixel(psm int) (int, error) {
+	switch psm {
+	case PSMCT32:
+		return 32, nil
+	case PSMCT16:
+		return 16, nil
+	case PSMT8, PSMT8H:
+		return 8, nil
+	case PSMT4, PSMT4HL, PSMT4HH:
+		return 4, nil
+	}
+	return 0, fmt.Errorf("Unsupported pixel storage mode 0x%x", psm)
+}
+
+// Blocks in page of PSMCT32 and PSMT8, 8x4 blocks
+func blockIndex32(bx, by int) int {
+	return (bx & 1) + (bx>>1&1)*4 + (bx>>2)*16 + (by&1)*2 + (by>>1)*8
+}
+
+// Blocks in page of PSMCT16 and PSMT4, 4x8 blocks
+func blockIndex16(bx, by int) int {
+	return (bx&1)*2 + (bx>>1)*8 + (by & 1) + (by>>1&1)*4 + (by>>2)*16
+}
+
+// Word in block of 8x8 pixels, column is 8x2 pixels
+func columnWord32(x, y int) int {
+	return (y>>1)*16 + (y&1)*2 + (x>>1)*4 + (x & 1)
+}
+
+// Halfword in block of 16x8 pixels, column is 16x2 pixels
+func columnHalf16(x, y int) int {
+	return (y>>1)*32 + (y&1)*4 + (x&7>>1)*8 + (x&1)*2 + (x >> 3)
+}
+
+// Byte in block of 16x16 pixels, column is 16x4 pixels.
+// Rows 2,3 of even columns and rows 0,1 of odd columns are shifted by 4 pixels
+func columnByte8(x, y int) int {
+	column, row := y>>2, y&3
+	if (row>>1)^(column&1) != 0 {
+		x ^= 4
+	}
+	return column*64 + (x&7>>1)*16 + (x&1)*4 + (x>>3)*2 + (row&1)*8 + (row >> 1)
+}
+
+// Nibble in block of 32x16 pixels, column is 32x4 pixels.
+// Shifted like PSMT8 columns
+func columnNibble4(x, y int) int {
+	column, row := y>>2, y&3
+	sx := x
+	if (row>>1)^(column&1) != 0 {
+		sx ^= 4
+	}
+	return column*128 + (sx&7>>1)*32 + (sx&1)*8 + (x>>3)*2 + (row&1)*16 + (row >> 1)
+}
+
+// Returns byte address of pixel and bit shift of 4 bit pixels in byte.
+// bp is base pointer in blocks, bw is buffer width in 64 pixels units
+func PixelAddress(psm int, bp int, bw int, x int, y int) (int, uint, error) {
+	pageW, pageH, err := PageSize(psm)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// buffer width counted in 64 pixels, pages of 8 and 4 bit formats are 128 pixels
+	pagesInRow := bw * 64 / pageW
+	if pagesInRow < 1 {
+		pagesInRow = 1
+	}
+	page := (y/pageH)*pagesInRow + x/pageW
+	base := bp*BLOCK_SIZE + page*PAGE_SIZE
+	px, py := x%pageW, y%pageH
+
+	switch psm {
+	case PSMCT32, PSMT8H, PSMT4HL, PSMT4HH:
+		addr := base + blockIndex32(px/8, py/8)*BLOCK_SIZE + columnWord32(px%8, py%8)*4
+		switch psm {
+		case PSMT8H:
+			return addr + 3, 0, nil
+		case PSMT4HL:
+			return addr + 3, 0, nil
+		case PSMT4HH:
+			return addr + 3, 4, nil
+		}
+		return addr, 0, nil
+	case PSMCT16:
+		return base + blockIndex16(px/16, py/8)*BLOCK_SIZE + columnHalf16(px%16, py%8)*2, 0, nil
+	case PSMT8:
+		return base + blockIndex32(px/16, py/16)*BLOCK_SIZE + columnByte8(px%16, py%16), 0, nil
+	default: // PSMT4
+		nibble := columnNibble4(px%32, py%16)
+		return base + blockIndex16(px/32, py/16)*BLOCK_SIZE + nibble/2, uint(nibble&1) * 4, nil
+	}
+}
+
+// Transfers rectangle of pixels between memory and linear data in host order.
+// 4 bit pixels packed two in byte, low nibble first
+func (m *Memory) transfer(psm int, bp int, bw int, width int, height int, data []byte, write bool) error {
+	bpp, err := BitsPerPixel(psm)
+	if err != nil {
+		return err
+	}
+	if need := (width*height*bpp + 7) / 8; len(data) < need {
+		return fmt.Errorf("Data too small: %d bytes, required %d", len(data), need)
+	}
+
+	bytesPerPixel := bpp / 8
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			addr, shift, err := PixelAddress(psm, bp, bw, x, y)
+			if err != nil {
+				return err
+			}
+
+			i := y*width + x
+			if bpp == 4 {
+				addr %= len(m.Data)
+				hshift := uint(i&1) * 4
+				if write {
+					m.Data[addr] = m.Data[addr]&^(0xf<<shift) | (data[i/2]>>hshift&0xf)<<shift
+				} else {
+					data[i/2] = data[i/2]&^(0xf<<hshift) | (m.Data[addr]>>shift&0xf)<<hshift
+				}
+				continue
+			}
+
+			for b := 0; b < bytesPerPixel; b++ {
+				maddr := (addr + b) % len(m.Data)
+				if write {
+					m.Data[maddr] = data[i*bytesPerPixel+b]
+				} else {
+					data[i*bytesPerPixel+b] = m.Data[maddr]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Writes rectangle of pixels (linear host order) into memory
+func (m *Memory) Write(psm int, bp int, bw int, width int, height int, src []byte) error {
+	return m.transfer(psm, bp, bw, width, height, src, true)
+}
+
+// Reads rectangle of pixels from memory into linear host order
+func (m *Memory) Read(psm int, bp int, bw int, width int, height int, dst []byte) error {
+	return m.transfer(psm, bp, bw, width, height, dst, false)
+}
+
+// Returns size of rectangle in upload format which covers same pages
+// as rectangle of width x height in format psm
+func uploadSize(psm int, upload int, width int, height int) (int, int, int, int, error) {
+	pw, ph, err := PageSize(psm)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	uw, uh, err := PageSize(upload)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+
+	uwidth, uheight := width*uw/pw, height*uh/ph
+	if uwidth*pw != width*uw || uheight*ph != height*uh {
+		return 0, 0, 0, 0, fmt.Errorf("Size %dx%d can not be converted between formats 0x%x and 0x%x", width, height, psm, upload)
+	}
+
+	// buffer width in 64 pixels, rounded up to page
+	bw := (width + pw - 1) / pw * pw / 64
+	ubw := (uwidth + uw - 1) / uw * uw / 64
+	return uwidth, uheight, bw, ubw, nil
+}
+
+// Memory for conversion of texture, whole pages of format psm
+func convertMemory(width int, height int, psm int) *Memory {
+	pw, ph, _ := PageSize(psm)
+	pages := (width + pw - 1) / pw * ((height + ph - 1) / ph)
+	return &Memory{Data: make([]byte, pages*PAGE_SIZE)}
+}
+
+// Checks that texture can be uploaded as rectangle of format upload.
+// Rectangles smaller than page can occupy different memory, so
+// check that upload rectangle clears every byte of texture
+func checkUpload(width int, height int, psm int, upload int) error {
+	uwidth, uheight, bw, ubw, err := uploadSize(psm, upload, width, height)
+	if err != nil {
+		return err
+	}
+
+	mem := convertMemory(width, height, psm)
+	bpp, _ := BitsPerPixel(psm)
+	ubpp, _ := BitsPerPixel(upload)
+
+	if err := mem.Write(psm, 0, bw, width, height, bytes.Repeat([]byte{0xff}, (width*height*bpp+7)/8)); err != nil {
+		return err
+	}
+	if err := mem.Write(upload, 0, ubw, uwidth, uheight, make([]byte, (uwidth*uheight*ubpp+7)/8)); err != nil {
+		return err
+	}
+	for _, b := range mem.Data {
+		if b != 0 {
+			return fmt.Errorf("Texture %dx%d of format 0x%x and upload rectangle %dx%d of format 0x%x occupy different memory",
+				width, height, psm, uwidth, uheight, upload)
+		}
+	}
+	return nil
+}
+
+// True if texture of format psm can be swizzled for upload as rectangle of format upload.
+// Usually false for textures smaller than page, games upload them in their own format
+func Uploadable(width int, height int, psm int, upload int) bool {
+	return checkUpload(width, height, psm, upload) == nil
+}
+
+func convert(data []byte, width int, height int, psm int, upload int, swizzle bool) ([]byte, error) {
+	if err := checkUpload(width, height, psm, upload); err != nil {
+		return nil, err
+	}
+	uwidth, uheight, bw, ubw, _ := uploadSize(psm, upload, width, height)
+
+	mem := convertMemory(width, height, psm)
+	bpp, _ := BitsPerPixel(psm)
+	ubpp, _ := BitsPerPixel(upload)
+	size := (width*height*bpp + 7) / 8
+	usize := (uwidth*uheight*ubpp + 7) / 8
+
+	if swizzle {
+		if err := mem.Write(psm, 0, bw, width, height, data); err != nil {
+			return nil, err
+		}
+		result := make([]byte, usize)
+		return result, mem.Read(upload, 0, ubw, uwidth, uheight, result)
+	} else {
+		if err := mem.Write(upload, 0, ubw, uwidth, uheight, data); err != nil {
+			return nil, err
+		}
+		result := make([]byte, size)
+		return result, mem.Read(psm, 0, bw, width, height, result)
+	}
+}
+
+// Returns linear pixels of texture in format psm, which data
+// was uploaded into memory as rectangle of format upload (usually PSMCT32)
+func Unswizzle(data []byte, width int, height int, psm int, upload int) ([]byte, error) {
+	return convert(data, width, height, psm, upload, false)
+}
+
+// Inverse of Unswizzle
+func Swizzle(data []byte, width int, height int, psm int, upload int) ([]byte, error) {
+	return convert(data, width, height, psm, upload, true)
+}
@@ -0,0 +1,192 @@
+package gs
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Tables of PCSX2 GS memory emulation (GSTables.cpp), rows are y
+var blockTable32 = [4][8]int{
+	{0, 1, 4, 5, 16, 17, 20, 21},
+	{2, 3, 6, 7, 18, 19, 22, 23},
+	{8, 9, 12, 13, 24, 25, 28, 29},
+	{10, 11, 14, 15, 26, 27, 30, 31},
+}
+
+var blockTable16 = [8][4]int{
+	{0, 2, 8, 10},
+	{1, 3, 9, 11},
+	{4, 6, 12, 14},
+	{5, 7, 13, 15},
+	{16, 18, 24, 26},
+	{17, 19, 25, 27},
+	{20, 22, 28, 30},
+	{21, 23, 29, 31},
+}
+
+var columnTable32 = [8][8]int{
+	{0, 1, 4, 5, 8, 9, 12, 13},
+	{2, 3, 6, 7, 10, 11, 14, 15},
+	{16, 17, 20, 21, 24, 25, 28, 29},
+	{18, 19, 22, 23, 26, 27, 30, 31},
+	{32, 33, 36, 37, 40, 41, 44, 45},
+	{34, 35, 38, 39, 42, 43, 46, 47},
+	{48, 49, 52, 53, 56, 57, 60, 61},
+	{50, 51, 54, 55, 58, 59, 62, 63},
+}
+
+var columnTable16 = [4][16]int{
+	{0, 2, 8, 10, 16, 18, 24, 26, 1, 3, 9, 11, 17, 19, 25, 27},
+	{4, 6, 12, 14, 20, 22, 28, 30, 5, 7, 13, 15, 21, 23, 29, 31},
+	{32, 34, 40, 42, 48, 50, 56, 58, 33, 35, 41, 43, 49, 51, 57, 59},
+	{36, 38, 44, 46, 52, 54, 60, 62, 37, 39, 45, 47, 53, 55, 61, 63},
+}
+
+var columnTable8 = [8][16]int{
+	{0, 4, 16, 20, 32, 36, 48, 52, 2, 6, 18, 22, 34, 38, 50, 54},
+	{8, 12, 24, 28, 40, 44, 56, 60, 10, 14, 26, 30, 42, 46, 58, 62},
+	{33, 37, 49, 53, 1, 5, 17, 21, 35, 39, 51, 55, 3, 7, 19, 23},
+	{41, 45, 57, 61, 9, 13, 25, 29, 43, 47, 59, 63, 11, 15, 27, 31},
+	{96, 100, 112, 116, 64, 68, 80, 84, 98, 102, 114, 118, 66, 70, 82, 86},
+	{104, 108, 120, 124, 72, 76, 88, 92, 106, 110, 122, 126, 74, 78, 90, 94},
+	{65, 69, 81, 85, 97, 101, 113, 117, 67, 71, 83, 87, 99, 103, 115, 119},
+	{73, 77, 89, 93, 105, 109, 121, 125, 75, 79, 91, 95, 107, 111, 123, 127},
+}
+
+var columnTable4 = [3][32]int{
+	{0, 8, 32, 40, 64, 72, 96, 104, 2, 10, 34, 42, 66, 74, 98, 106,
+		4, 12, 36, 44, 68, 76, 100, 108, 6, 14, 38, 46, 70, 78, 102, 110},
+	{16, 24, 48, 56, 80, 88, 112, 120, 18, 26, 50, 58, 82, 90, 114, 122,
+		20, 28, 52, 60, 84, 92, 116, 124, 22, 30, 54, 62, 86, 94, 118, 126},
+	{65, 73, 97, 105, 1, 9, 33, 41, 67, 75, 99, 107, 3, 11, 35, 43,
+		69, 77, 101, 109, 5, 13, 37, 45, 71, 79, 103, 111, 7, 15, 39, 47},
+}
+
+func TestTables(t *testing.T) {
+	for y, row := range blockTable32 {
+		for x, want := range row {
+			if got := blockIndex32(x, y); got != want {
+				t.Errorf("blockIndex32(%d, %d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+	for y, row := range blockTable16 {
+		for x, want := range row {
+			if got := blockIndex16(x, y); got != want {
+				t.Errorf("blockIndex16(%d, %d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+	for y, row := range columnTable32 {
+		for x, want := range row {
+			if got := columnWord32(x, y); got != want {
+				t.Errorf("columnWord32(%d, %d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+	for y, row := range columnTable16 {
+		for x, want := range row {
+			if got := columnHalf16(x, y); got != want {
+				t.Errorf("columnHalf16(%d, %d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+	for y, row := range columnTable8 {
+		for x, want := range row {
+			if got := columnByte8(x, y); got != want {
+				t.Errorf("columnByte8(%d, %d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+	for y, row := range columnTable4 {
+		for x, want := range row {
+			if got := columnNibble4(x, y); got != want {
+				t.Errorf("columnNibble4(%d, %d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+}
+
+// Swizzle formula of 8 bit textures used by txr before this package
+func legacySwizzlePos(x, y, width int) int {
+	block_location := (y&(math.MaxInt32^0xf))*width + (x&(math.MaxInt32^0xf))*2
+	swap_selector := (((y + 2) >> 2) & 0x1) * 4
+	posY := (((y & (math.MaxInt32 ^ 3)) >> 1) + (y & 1)) & 0x7
+	column_location := posY*width*2 + ((x+swap_selector)&0x7)*4
+
+	byte_num := ((y >> 1) & 1) + ((x >> 2) & 2) // 0,1,2,3
+
+	return block_location + column_location + byte_num
+}
+
+func TestUnswizzle8MatchesLegacy(t *testing.T) {
+	for width := 16; width <= 1024; width *= 2 {
+		for height := 16; height <= 1024; height *= 2 {
+			if !Uploadable(width, height, PSMT8, PSMCT32) {
+				continue
+			}
+
+			data := make([]byte, width*height)
+			rand.New(rand.NewSource(int64(width * height))).Read(data)
+
+			linear, err := Unswizzle(data, width, height, PSMT8, PSMCT32)
+			if err != nil {
+				t.Fatalf("%dx%d: %v", width, height, err)
+			}
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					if linear[x+y*width] != data[legacySwizzlePos(x, y, width)] {
+						t.Fatalf("%dx%d: pixel %d,%d differs from legacy formula", width, height, x, y)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	sizes := [][2]int{{64, 32}, {128, 64}, {128, 128}, {256, 256}, {512, 128}, {128, 512}}
+	for _, psm := range []int{PSMCT32, PSMCT16, PSMT8, PSMT4} {
+		bpp, _ := BitsPerPixel(psm)
+		tested := 0
+		for _, size := range sizes {
+			width, height := size[0], size[1]
+			if !Uploadable(width, height, psm, PSMCT32) {
+				continue
+			}
+
+			data := make([]byte, width*height*bpp/8)
+			rand.New(rand.NewSource(int64(psm))).Read(data)
+
+			swizzled, err := Swizzle(data, width, height, psm, PSMCT32)
+			if err != nil {
+				t.Fatalf("psm 0x%x %dx%d: %v", psm, width, height, err)
+			}
+			if psm != PSMCT32 && bytes.Equal(swizzled, data) {
+				t.Errorf("psm 0x%x %dx%d: swizzle changed nothing", psm, width, height)
+			}
+			linear, err := Unswizzle(swizzled, width, height, psm, PSMCT32)
+			if err != nil {
+				t.Fatalf("psm 0x%x %dx%d: %v", psm, width, height, err)
+			}
+			if !bytes.Equal(linear, data) {
+				t.Errorf("psm 0x%x %dx%d: round trip differs", psm, width, height)
+			}
+			tested++
+		}
+		if tested == 0 {
+			t.Errorf("psm 0x%x: no uploadable sizes tested", psm)
+		}
+	}
+}
+
+func TestNotUploadable(t *testing.T) {
+	if Uploadable(8, 8, PSMT8, PSMCT32) {
+		t.Error("8x8 PSMT8 texture must not be uploadable as PSMCT32")
+	}
+	if _, err := Unswizzle(make([]byte, 64), 8, 8, PSMT8, PSMCT32); err == nil {
+		t.Error("No error for 8x8 PSMT8 texture")
+	}
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+type quantColor struct {
+	c     [4]uint8 // r, g, b, a
+	count int
+}
+
+type quantBox []quantColor
+
+// Returns channel with largest range and this range
+func (b quantBox) widest() (int, int) {
+	channel, width := 0, -1
+	for ch := 0; ch < 4; ch++ {
+		min, max := 255, 0
+		for _, qc := range b {
+			v := int(qc.c[ch])
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+		}
+		if max-min > width {
+			channel, width = ch, max-min
+		}
+	}
+	return channel, width
+}
+
+// Average color weighted by pixels count
+func (b quantBox) average() color.NRGBA {
+	var sum [4]int
+	total := 0
+	for _, qc := range b {
+		for ch := range sum {
+			sum[ch] += int(qc.c[ch]) * qc.count
+		}
+		total += qc.count
+	}
+	return color.NRGBA{
+		R: uint8((sum[0] + total/2) / total),
+		G: uint8((sum[1] + total/2) / total),
+		B: uint8((sum[2] + total/2) / total),
+		A: uint8((sum[3] + total/2) / total),
+	}
+}
+
+// Reduces colors of image to palette of exactly count colors with median cut.
+// Pixels are taken as color.NRGBA, unused palette entries are zero
+func QuantizeImage(img image.Image, count int) color.Palette {
+	counts := make(map[[4]uint8]int)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			counts[[4]uint8{c.R, c.G, c.B, c.A}]++
+		}
+	}
+
+	colors := make(quantBox, 0, len(counts))
+	for c, n := range counts {
+		colors = append(colors, quantColor{c: c, count: n})
+	}
+	// map order is random, keep result stable
+	sort.Slice(colors, func(i, j int) bool {
+		ci, cj := colors[i].c, colors[j].c
+		for ch := range ci {
+			if ci[ch] != cj[ch] {
+				return ci[ch] < cj[ch]
+			}
+		}
+		return false
+	})
+
+	boxes := make([]quantBox, 0, count)
+	if len(colors) != 0 {
+		boxes = append(boxes, colors)
+	}
+
+	for len(boxes) < count {
+		// split box with widest channel range at weighted median
+		ibox, channel, width := -1, 0, 0
+		for i, b := range boxes {
+			if len(b) < 2 {
+				continue
+			}
+			if ch, w := b.widest(); w > width {
+				ibox, channel, width = i, ch, w
+			}
+		}
+		if ibox < 0 {
+			break
+		}
+
+		b := boxes[ibox]
+		sort.SliceStable(b, func(i, j int) bool { return b[i].c[channel] < b[j].c[channel] })
+
+		total := 0
+		for _, qc := range b {
+			total += qc.count
+		}
+		split, half := 1, 0
+		for i, qc := range b[:len(b)-1] {
+			half += qc.count
+			split = i + 1
+			if half*2 >= total {
+				break
+			}
+		}
+
+		boxes[ibox] = b[:split]
+		boxes = append(boxes, b[split:])
+	}
+
+	palette := make(color.Palette, count)
+	for i := range palette {
+		if i < len(boxes) {
+			palette[i] = boxes[i].average()
+		} else {
+			palette[i] = color.NRGBA{}
+		}
+	}
+	return palette
+}
+
+// Returns index of palette color nearest to c. Colors compared as color.NRGBA,
+// without premultiplied alpha, so transparent colors keep their rgb
+func NearestColor(palette color.Palette, c color.Color) int {
+	clr := color.NRGBAModel.Convert(c).(color.NRGBA)
+	best, bestDist := 0, -1
+	for i, pc := range palette {
+		p := color.NRGBAModel.Convert(pc).(color.NRGBA)
+		dr, dg, db, da := int(p.R)-int(clr.R), int(p.G)-int(clr.G), int(p.B)-int(clr.B), int(p.A)-int(clr.A)
+		dist := dr*dr + dg*dg + db*db + da*da
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+			if dist == 0 {
+				break
+			}
+		}
+	}
+	return best
+}
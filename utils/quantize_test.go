@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestQuantizeCount(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	many := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	rnd.Read(many.Pix)
+
+	few := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	fewColors := []color.NRGBA{{10, 20, 30, 255}, {200, 0, 0, 128}, {0, 0, 0, 0}}
+	for i := 0; i < 64; i++ {
+		few.SetNRGBA(i%8, i/8, fewColors[i%len(fewColors)])
+	}
+
+	for _, count := range []int{1, 2, 16, 256} {
+		for name, img := range map[string]image.Image{"many": many, "few": few, "empty": image.NewNRGBA(image.Rect(0, 0, 0, 0))} {
+			if pal := QuantizeImage(img, count); len(pal) != count {
+				t.Errorf("%s colors: %d colors returned, want %d", name, len(pal), count)
+			}
+		}
+	}
+
+	// image with less colors than palette stored exactly
+	pal := QuantizeImage(few, 16)
+	for _, c := range fewColors {
+		if pal[NearestColor(pal, c)] != c {
+			t.Errorf("Color %v not in palette", c)
+		}
+	}
+}
+
+func TestNearestColor(t *testing.T) {
+	pal := color.Palette{color.NRGBA{255, 0, 0, 0}, color.NRGBA{0, 255, 0, 0}, color.NRGBA{0, 0, 255, 255}}
+	// transparent colors differ only without premultiplied alpha
+	if i := NearestColor(pal, color.NRGBA{0, 250, 0, 0}); i != 1 {
+		t.Errorf("Transparent green matched to %d", i)
+	}
+	if i := NearestColor(pal, color.NRGBA{0, 0, 200, 250}); i != 2 {
+		t.Errorf("Blue matched to %d", i)
+	}
+}
@@ -0,0 +1,125 @@
+package wav
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Loop points in samples (per channel), End is exclusive
+type Loop struct {
+	Start uint32
+	End   uint32
+}
+
+// 16-bit PCM sound
+type Wav struct {
+	Channels   int
+	SampleRate int
+	Samples    []int16 // interleaved by channels
+	Loop       *Loop   // can be nil
+}
+
+func writeChunk(w io.Writer, id string, data ...interface{}) error {
+	size := 0
+	for _, d := range data {
+		size += binary.Size(d)
+	}
+
+	if _, err := w.Write([]byte(id)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(size)); err != nil {
+		return err
+	}
+	for _, d := range data {
+		if err := binary.Write(w, binary.LittleEndian, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type fmtChunk struct {
+	Format        uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+type smplChunk struct {
+	Manufacturer      uint32
+	Product           uint32
+	SamplePeriod      uint32
+	MIDIUnityNote     uint32
+	MIDIPitchFraction uint32
+	SMPTEFormat       uint32
+	SMPTEOffset       uint32
+	SampleLoops       uint32
+	SamplerData       uint32
+}
+
+type smplLoop struct {
+	CuePointID uint32
+	Type       uint32
+	Start      uint32
+	End        uint32 // inclusive
+	Fraction   uint32
+	PlayCount  uint32 // 0 - infinite
+}
+
+func (wav *Wav) Encode(w io.Writer) error {
+	if wav.Channels <= 0 || wav.SampleRate <= 0 {
+		return errors.New("Wrong wav channels count or sample rate")
+	}
+
+	format := fmtChunk{
+		Format:        1, // PCM
+		Channels:      uint16(wav.Channels),
+		SampleRate:    uint32(wav.SampleRate),
+		ByteRate:      uint32(wav.SampleRate * wav.Channels * 2),
+		BlockAlign:    uint16(wav.Channels * 2),
+		BitsPerSample: 16,
+	}
+
+	var loopChunks []interface{}
+	if wav.Loop != nil && wav.Loop.End > wav.Loop.Start {
+		loopChunks = []interface{}{
+			smplChunk{
+				SamplePeriod:  uint32(1000000000 / wav.SampleRate),
+				MIDIUnityNote: 60,
+				SampleLoops:   1,
+			},
+			smplLoop{
+				Start: wav.Loop.Start,
+				End:   wav.Loop.End - 1,
+			},
+		}
+	}
+
+	riffSize := 4 + 8 + binary.Size(format) + 8 + len(wav.Samples)*2
+	if loopChunks != nil {
+		riffSize += 8 + binary.Size(loopChunks[0]) + binary.Size(loopChunks[1])
+	}
+
+	if _, err := w.Write([]byte("RIFF")); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(riffSize)); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("WAVE")); err != nil {
+		return err
+	}
+	if err := writeChunk(w, "fmt ", format); err != nil {
+		return err
+	}
+	if loopChunks != nil {
+		if err := writeChunk(w, "smpl", loopChunks...); err != nil {
+			return err
+		}
+	}
+	return writeChunk(w, "data", wav.Samples)
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Writes structs, slices, maps and scalars as yaml document.
+// Struct fields named by json tags, "omitempty" respected
+func WriteYaml(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, "---\n"); err != nil {
+		return err
+	}
+	// document start acts like list item, so no new line needed
+	return writeYamlValue(w, reflect.ValueOf(v), 0, true)
+}
+
+func yamlScalar(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String()), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "null", true
+		}
+	case reflect.Slice, reflect.Map:
+		// nil written like json does
+		if v.IsNil() {
+			return "null", true
+		}
+		if v.Len() == 0 {
+			if v.Kind() == reflect.Slice {
+				return "[]", true
+			}
+			return "{}", true
+		}
+	case reflect.Struct:
+		return "", false
+	default:
+		return "", false
+	}
+	return "", false
+}
+
+type yamlField struct {
+	name  string
+	value reflect.Value
+}
+
+func yamlFields(v reflect.Value) []yamlField {
+	fields := make([]yamlField, 0)
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.PkgPath != "" {
+				continue // unexported
+			}
+			name := sf.Name
+			tag := strings.Split(sf.Tag.Get("json"), ",")
+			if tag[0] == "-" {
+				continue
+			} else if tag[0] != "" {
+				name = tag[0]
+			}
+			fv := v.Field(i)
+			if len(tag) > 1 && tag[1] == "omitempty" && fv.IsZero() {
+				continue
+			}
+			fields = append(fields, yamlField{name: name, value: fv})
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			fields = append(fields, yamlField{name: fmt.Sprint(k.Interface()), value: v.MapIndex(k)})
+		}
+		// map order is random, keep output stable
+		sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	}
+	return fields
+}
+
+// Follows pointers and interfaces until nil or other kind
+func yamlDeref(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			break
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// inList - value is item of list, so first line already has "- " prefix
+func writeYamlValue(w io.Writer, v reflect.Value, indent int, inList bool) error {
+	v = yamlDeref(v)
+
+	prefix := strings.Repeat("  ", indent)
+
+	if s, ok := yamlScalar(v); ok {
+		_, err := fmt.Fprintf(w, "%s\n", s)
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if !inList {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < v.Len(); i++ {
+			if _, err := fmt.Fprintf(w, "%s- ", prefix); err != nil {
+				return err
+			}
+			if err := writeYamlValue(w, v.Index(i), indent+1, true); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct, reflect.Map:
+		for i, f := range yamlFields(v) {
+			fieldPrefix := prefix
+			if i == 0 && inList {
+				fieldPrefix = ""
+			} else if i == 0 {
+				if _, err := io.WriteString(w, "\n"); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprintf(w, "%s%s:", fieldPrefix, f.name); err != nil {
+				return err
+			}
+			if _, ok := yamlScalar(yamlDeref(f.value)); ok {
+				if _, err := io.WriteString(w, " "); err != nil {
+					return err
+				}
+			}
+			if err := writeYamlValue(w, f.value, indent+1, false); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Unsupported yaml value kind %v", v.Kind())
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type yamlLine struct {
+	col  int
+	text string
+}
+
+// Parses subset of yaml produced by WriteYaml: block mappings and lists,
+// scalars in json form
+func parseTestYaml(t *testing.T, lines []yamlLine) interface{} {
+	pos := 0
+	var parse func(col int) interface{}
+	parse = func(col int) interface{} {
+		if strings.HasPrefix(lines[pos].text, "- ") {
+			list := make([]interface{}, 0)
+			for pos < len(lines) && lines[pos].col == col && strings.HasPrefix(lines[pos].text, "- ") {
+				// item content starts after "- "
+				lines[pos] = yamlLine{col: col + 2, text: lines[pos].text[2:]}
+				list = append(list, parse(col+2))
+			}
+			return list
+		}
+
+		if !strings.Contains(lines[pos].text, ":") || strings.HasPrefix(lines[pos].text, "\"") {
+			return parseTestScalar(t, lines[pos].text, &pos)
+		}
+
+		m := make(map[string]interface{})
+		for pos < len(lines) && lines[pos].col == col {
+			i := strings.Index(lines[pos].text, ":")
+			if i < 0 {
+				t.Fatalf("Line %d is not mapping entry: %q", pos, lines[pos].text)
+			}
+			key, rest := lines[pos].text[:i], lines[pos].text[i+1:]
+			if rest == "" {
+				pos++
+				if pos >= len(lines) || lines[pos].col <= col {
+					t.Fatalf("Line %d: no value for key %q", pos, key)
+				}
+				m[key] = parse(lines[pos].col)
+			} else {
+				if rest[0] != ' ' {
+					t.Fatalf("Line %d: no space after key %q", pos, key)
+				}
+				m[key] = parseTestScalar(t, rest[1:], &pos)
+			}
+		}
+		return m
+	}
+
+	return parse(0)
+}
+
+func parseTestScalar(t *testing.T, s string, pos *int) interface{} {
+	*pos++
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("Wrong scalar %q: %v", s, err)
+	}
+	return v
+}
+
+type yamlTestItem struct {
+	Name  string            `json:"name"`
+	Next  *yamlTestItem     `json:"next"`
+	Tags  []string          `json:"tags"`
+	Attrs map[string]int    `json:"attrs"`
+	Skip  string            `json:"skip,omitempty"`
+	Subs  []*yamlTestItem   `json:"subs,omitempty"`
+	Extra map[string]string `json:"extra"`
+}
+
+func TestWriteYamlMatchesJson(t *testing.T) {
+	v := &yamlTestItem{
+		Name:  "root \"quoted\"",
+		Tags:  []string{"a", "b"},
+		Attrs: map[string]int{"z": 1, "a": 2, "m": 3},
+		Subs: []*yamlTestItem{
+			{Name: "sub", Next: &yamlTestItem{Name: "next", Tags: []string{}}},
+			{Name: "empty"},
+		},
+		Extra: map[string]string{},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteYaml(&buf, v); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+
+	var again bytes.Buffer
+	if err := WriteYaml(&again, v); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != text {
+		t.Fatal("Output is not stable")
+	}
+	if !strings.Contains(text, "attrs:\n  a: 2\n  m: 3\n  z: 1\n") {
+		t.Fatalf("Map keys not sorted:\n%s", text)
+	}
+
+	rows := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if rows[0] != "---" {
+		t.Fatalf("No document start:\n%s", text)
+	}
+	lines := make([]yamlLine, 0, len(rows))
+	for _, row := range rows[1:] {
+		trimmed := strings.TrimLeft(row, " ")
+		lines = append(lines, yamlLine{col: len(row) - len(trimmed), text: trimmed})
+	}
+	got := parseTestYaml(t, lines)
+
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want interface{}
+	if err := json.Unmarshal(jsonData, &want); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Yaml differs from json:\n%s\njson: %s", text, jsonData)
+	}
+}